@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+var archiveContentTypes = map[string]string{
+	"tar.gz": "application/gzip",
+	"tgz":    "application/gzip",
+	"zip":    "application/zip",
+}
+
+var unsafeFilename = regexp.MustCompile("[^a-zA-Z0-9._-]+")
+
+func sendRepoArchive(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	// ref may contain slashes: release/1.0.tar.gz
+	archive := vars["archive"]
+	ref, format := "", ""
+	for ext := range archiveContentTypes {
+		if strings.HasSuffix(archive, "."+ext) {
+			ref = strings.TrimSuffix(archive, "."+ext)
+			format = ext
+		}
+	}
+	if ref == "" {
+		writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("Archive `%s` must be <ref>.tar.gz, <ref>.tgz, or <ref>.zip", archive))
+		return
+	}
+	path := req.URL.Query().Get("path")
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+
+	err := repo.ArchiveCheck(repoId, ref, path, format)
+	if err != nil {
+		message := fmt.Sprintf("Unable to archive Git repo `%s`: %v", repoId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+
+	name := unsafeFilename.ReplaceAllString(
+		fmt.Sprintf("%s-%s", filepath.Base(repoId), strings.Replace(ref, "/", "-", -1)), "_")
+	if config.Verbose {
+		log.Printf("Sending repo `%s` archive `%s`", repoId, archive)
+	}
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	w.WriteHeader(http.StatusOK)
+	err = repo.Archive(repoId, ref, path, format, name, w)
+	if err != nil {
+		log.Printf("Got error from Git while archiving repo `%s`: %v", repoId, err)
+		// status is already sent, break the connection so that client won't take truncated archive as complete
+		panic(http.ErrAbortHandler)
+	}
+}
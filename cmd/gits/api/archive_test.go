@@ -0,0 +1,68 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func archiveRepo(t *testing.T, repoId string) string {
+	t.Helper()
+	_, dir := testRepo(t, repoId, map[string]string{"README": "readme\n"})
+	return dir
+}
+
+func TestArchiveErrors(t *testing.T) {
+	dir := archiveRepo(t, "archive/archive-1")
+	server := httptest.NewServer(getRouter())
+	defer server.Close()
+
+	get := func(archive string) (*http.Response, []byte, error) {
+		req, err := http.NewRequest("GET", server.URL+"/api/v1/repositories/archive/archive-1/archive/"+archive, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Secret", config.GitApiSecret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return resp, body, err
+	}
+
+	resp, body, err := get("master.zip")
+	if err != nil || resp.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Fatalf("Unexpected response %v: %v", resp, err)
+	}
+
+	// errors are reported before the response is started
+	for archive, status := range map[string]int{
+		"missing.tgz":    http.StatusNotFound,
+		"--output=x.tgz": http.StatusBadRequest,
+		"master.rar":     http.StatusBadRequest,
+	} {
+		resp, _, err := get(archive)
+		if err != nil || resp.StatusCode != status {
+			t.Errorf("Expected %d for `%s`, got %v: %v", status, archive, resp, err)
+		}
+	}
+
+	// blob is lost, archive fails after the status is set; the connection is broken
+	// either before the headers are flushed or in the middle of the body
+	blob := gitCommand(t, dir, "rev-parse", "master:README")
+	err = os.Remove(filepath.Join(dir, "objects", blob[:2], blob[2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err = get("master.tar.gz")
+	if err == nil {
+		t.Errorf("Expected broken response, got %v", resp)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -14,6 +15,17 @@ import (
 	"github.com/agilestacks/git-service/cmd/gits/repo"
 )
 
+// etagMatch returns true if If-None-Match header lists the ETag
+func etagMatch(req *http.Request, etag string) bool {
+	for _, tag := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func sendRepoBlob(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	repoId := getRepositoryId(vars["organization"], vars["repository"])
@@ -21,28 +33,44 @@ func sendRepoBlob(w http.ResponseWriter, req *http.Request) {
 
 	ref := req.URL.Query().Get("ref")
 
-	blobReader, err := repo.Blob(repoId, ref, path)
+	blob, err := repo.BlobEntry(repoId, ref, path)
+	var blobReader io.ReadCloser
+	if err == nil {
+		etag := fmt.Sprintf("\"%s\"", blob.Id)
+		w.Header().Set("ETag", etag)
+		if etagMatch(req, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		blobReader, err = repo.BlobContent(repoId, blob.Id)
+	}
 	if err != nil {
 		message := fmt.Sprintf("Unable to obtain Git repo `%s` blob `%s`: %v", repoId, path, err)
 		log.Print(message)
 		status := http.StatusInternalServerError
 		if strings.Contains(err.Error(), "not found") {
 			status = http.StatusNotFound
-		} else if strings.Contains(err.Error(), "is a directory") {
+		} else if strings.Contains(err.Error(), "is a directory") || strings.Contains(err.Error(), "not supported") {
 			status = http.StatusBadRequest
 		} else if strings.Contains(err.Error(), "not a regular file") {
 			status = http.StatusConflict
-		} else if strings.Contains(err.Error(), "file too big") {
-			status = http.StatusNotImplemented
 		}
+		w.Header().Del("ETag")
 		writeError(w, status, message)
 	} else {
-		defer blobReader.Close()
 		if config.Verbose {
 			log.Printf("Sending repo `%s` blob `%s`", repoId, path)
 		}
 		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(*blob.Size, 10))
 		w.WriteHeader(http.StatusOK)
-		io.Copy(w, blobReader)
+		_, err = io.Copy(w, blobReader)
+		if err != nil {
+			log.Printf("Unable to send repo `%s` blob `%s`: %v", repoId, path, err)
+		}
+		err = blobReader.Close()
+		if err != nil {
+			log.Printf("Got error from Git while reading repo `%s` blob `%s`: %v", repoId, path, err)
+		}
 	}
 }
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func TestBlob(t *testing.T) {
+	work, dir := testRepo(t, "blob/blob-1", map[string]string{"README": "readme\n", "docs/guide": "guide\n"})
+	err := os.Symlink("README", filepath.Join(work, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitCommand(t, work, "add", "link")
+	gitCommand(t, work, "commit", "-q", "-m", "Link")
+	gitCommand(t, work, "push", "-q", dir, "master")
+	id := gitCommand(t, work, "rev-parse", "master:README")
+
+	url := "/api/v1/repositories/blob/blob-1/blob/"
+	rr := testGet(t, url+"README", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "readme\n" {
+		t.Fatalf("Unexpected response %v: %q", rr.Code, rr.Body.String())
+	}
+	if etag := rr.Header().Get("ETag"); etag != "\""+id+"\"" {
+		t.Errorf("Expected ETag of blob %s, got %s", id, etag)
+	}
+	if length := rr.Header().Get("Content-Length"); length != "7" {
+		t.Errorf("Expected Content-Length 7, got %s", length)
+	}
+
+	rr = testGet(t, url+"docs/guide?ref=master~1", http.Header{"If-None-Match": {"\"other\", W/\"" + id + "\""}})
+	if rr.Code != http.StatusOK || rr.Body.String() != "guide\n" {
+		t.Errorf("Expected guide sent on ETag mismatch, got %v: %q", rr.Code, rr.Body.String())
+	}
+	rr = testGet(t, url+"README", http.Header{"If-None-Match": {"\"other\", W/\"" + id + "\""}})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected 304, got %v: %q", rr.Code, rr.Body.String())
+	}
+
+	for path, status := range map[string]int{
+		"missing":            http.StatusNotFound,
+		"README?ref=missing": http.StatusNotFound,
+		"docs":               http.StatusBadRequest,
+		"README?ref=-p":      http.StatusBadRequest,
+		"link":               http.StatusConflict,
+		"link?ref=master~1":  http.StatusNotFound,
+	} {
+		rr = testGet(t, url+path, nil)
+		if rr.Code != status {
+			t.Errorf("Expected %d for `%s`, got %v: %s", status, path, rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("ETag") != "" {
+			t.Errorf("Unexpected ETag for `%s`", path)
+		}
+	}
+}
+
+func TestBlobContentError(t *testing.T) {
+	testRepo(t, "blob/blob-2", nil)
+	reader, err := repo.BlobContent("blob/blob-2", strings.Repeat("0", 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(reader)
+	if err = reader.Close(); err == nil || len(content) != 0 {
+		t.Errorf("Expected Git error reading missing blob, got %q: %v", content, err)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+const bundleContentType = "application/x-git-bundle"
+
+func sendRepoBundle(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+	refs := req.URL.Query()["ref"]
+
+	err := repo.BundleCheck(repoId, refs)
+	if err != nil {
+		message := fmt.Sprintf("Unable to bundle Git repo `%s`: %v", repoId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+
+	if config.Verbose {
+		log.Printf("Sending repo `%s` bundle", repoId)
+	}
+	w.Header().Set("Content-Type", bundleContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.bundle\"", filepath.Base(repoId)))
+	w.WriteHeader(http.StatusOK)
+	err = repo.Bundle(repoId, refs, w)
+	if err != nil {
+		log.Printf("Got error from Git while bundling repo `%s`: %v", repoId, err)
+		// status is already sent, break the connection so that client won't take truncated bundle as complete
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/agilestacks/git-service/cmd/gits/extapi"
+)
+
+type InvalidateCacheResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+func invalidateCache(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	user := query.Get("user")
+	team := query.Get("team")
+	template := query.Get("template")
+	org := query.Get("organization")
+	invalidated := extapi.InvalidateCache(user, team, template, org)
+	log.Printf("Invalidated %d external API cache entries (user=%q team=%q template=%q organization=%q)",
+		invalidated, user, team, template, org)
+	writeJson(w, InvalidateCacheResponse{Invalidated: invalidated})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func diffErrorStatus(err error) int {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "not found") {
+		status = http.StatusNotFound
+	} else if strings.Contains(err.Error(), "multiple refs") {
+		status = http.StatusConflict
+	} else if strings.Contains(err.Error(), "not supported") {
+		status = http.StatusBadRequest
+	}
+	return status
+}
+
+func wantPatch(req *http.Request) bool {
+	patch := req.URL.Query().Get("patch")
+	return patch == "true" || patch == "1"
+}
+
+func sendRepoCompare(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	refs := strings.SplitN(vars["spec"], "...", 2)
+	if len(refs) != 2 || refs[0] == "" || refs[1] == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Expected `base...head`, got `%s`", vars["spec"]))
+		return
+	}
+
+	comparison, err := repo.Compare(repoId, refs[0], refs[1], wantPatch(req))
+	if err != nil {
+		message := fmt.Sprintf("Unable to compare Git repo `%s` `%s`: %v", repoId, vars["spec"], err)
+		log.Print(message)
+		writeError(w, diffErrorStatus(err), message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending repo `%s` comparison `%s`", repoId, vars["spec"])
+	}
+	writeJson(w, comparison)
+}
+
+func sendRepoCommit(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+	sha := vars["sha"]
+
+	commit, err := repo.Commit(repoId, sha, wantPatch(req))
+	if err != nil {
+		message := fmt.Sprintf("Unable to obtain Git repo `%s` commit `%s`: %v", repoId, sha, err)
+		log.Print(message)
+		writeError(w, diffErrorStatus(err), message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending repo `%s` commit `%s`", repoId, sha)
+	}
+	writeJson(w, commit)
+}
@@ -17,13 +17,19 @@ func createRepo(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	repoId := getRepositoryId(vars["organization"], vars["repository"])
 
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("Error reading request body: %v", err))
-		return
-	}
 	var createReq *repo.CreateRequest
+	var body []byte
+	var err error
+	if strings.HasPrefix(req.Header.Get("Content-Type"), bundleContentType) {
+		createReq = &repo.CreateRequest{Bundle: req.Body}
+	} else {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError,
+				fmt.Sprintf("Error reading request body: %v", err))
+			return
+		}
+	}
 	if len(body) > 4 {
 		var reqData repo.CreateRequest
 		err = json.Unmarshal(body, &reqData)
@@ -41,8 +47,12 @@ func createRepo(w http.ResponseWriter, req *http.Request) {
 		status := http.StatusInternalServerError
 		if strings.Contains(err.Error(), "exist") {
 			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
 		} else if strings.Contains(err.Error(), "not supported") {
 			status = http.StatusBadRequest
+		} else if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
 		} else if strings.Contains(err.Error(), "not implemented") {
 			status = http.StatusNotImplemented
 		} else if strings.Contains(err.Error(), "S3") {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -19,7 +20,11 @@ func deleteRepo(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		message := fmt.Sprintf("Unable to delete Git repo `%s`: %v", repoId, err)
 		log.Print(message)
-		writeError(w, http.StatusInternalServerError, message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
+		}
+		writeError(w, status, message)
 	} else {
 		if config.Verbose {
 			log.Printf("Repo `%s` deleted", repoId)
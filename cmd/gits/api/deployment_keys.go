@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+type DeploymentKeyRequest struct {
+	UserId  string     `json:"userId"`
+	Subject string     `json:"subject,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type DeploymentKeyResponse struct {
+	Key     string     `json:"key"`
+	KeyId   string     `json:"keyId"`
+	UserId  string     `json:"userId"`
+	Subject string     `json:"subject,omitempty"`
+	Issued  time.Time  `json:"issued"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func issueDeploymentKey(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	var request DeploymentKeyRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling JSON request: %v", err))
+		return
+	}
+	if request.UserId == "" {
+		writeError(w, http.StatusBadRequest, "Deployment key without `userId` is not supported")
+		return
+	}
+	if request.Subject != "" && (!strings.HasPrefix(request.Subject, "git:") || len(request.Subject) == len("git:")) {
+		writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("Deployment key subject `%s` is not supported, use `git:<template id>`", request.Subject))
+		return
+	}
+
+	issued := time.Now().UTC().Truncate(time.Second)
+	expires := request.Expires
+	if expires != nil {
+		at := expires.UTC().Truncate(time.Second)
+		expires = &at
+	} else if config.DeploymentKeyTtl > 0 {
+		at := issued.Add(config.DeploymentKeyTtl)
+		expires = &at
+	}
+	claims := DeploymentKeyClaims{UserId: request.UserId, Subject: request.Subject, Issued: issued.Unix()}
+	if expires != nil {
+		if !expires.After(issued) {
+			writeError(w, http.StatusBadRequest, "Deployment key expiry in the past is not supported")
+			return
+		}
+		claims.Expires = expires.Unix()
+	}
+
+	key, keyId, err := issueDeploymentKeyV2(&claims)
+	if err != nil {
+		message := fmt.Sprintf("Unable to issue deployment key for user `%s`: %v", request.UserId, err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Deployment key issued for user `%s` with key id `%s`", request.UserId, keyId)
+	}
+	data, err := json.Marshal(DeploymentKeyResponse{Key: key, KeyId: keyId, UserId: request.UserId,
+		Subject: request.Subject, Issued: issued, Expires: expires})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to marshall JSON: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+// tests must not touch -repo_dir default, `x/y` is the repository of info/refs tests
+func init() {
+	repoDir, err := ioutil.TempDir("", "gits-test-")
+	if err != nil {
+		panic(err)
+	}
+	config.RepoDir = repoDir
+	config.LockTimeout = time.Second
+	os.MkdirAll(filepath.Join(repoDir, "x", "y"), 0755)
+}
+
+func gitCommand(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testRepo commits `files` to master of a work repository and clones it to <repo_dir>/<repoId>,
+// returns work and bare repository directories; both are removed after the test
+func testRepo(t *testing.T, repoId string, files map[string]string) (string, string) {
+	t.Helper()
+	work, err := ioutil.TempDir("", "gits-work-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(work) })
+	gitCommand(t, work, "init", "-q")
+	gitCommand(t, work, "symbolic-ref", "HEAD", "refs/heads/master")
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		file := filepath.Join(work, path)
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte(files[path]), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gitCommand(t, work, "add", path)
+	}
+	gitCommand(t, work, "commit", "-q", "--allow-empty", "-m", "Initial")
+	dir := filepath.Join(config.RepoDir, repoId)
+	gitCommand(t, work, "clone", "-q", "--bare", work, dir)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(dir)) })
+	return work, dir
+}
+
+// testGet sends API request with API secret
+func testGet(t *testing.T, url string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-API-Secret", config.GitApiSecret)
+	rr := httptest.NewRecorder()
+	getRouter().ServeHTTP(rr, req)
+	return rr
+}
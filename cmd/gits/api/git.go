@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
 	"github.com/agilestacks/git-service/cmd/gits/repo"
+	"github.com/agilestacks/git-service/cmd/gits/tokens"
 )
 
 // used by api/ test
@@ -34,33 +36,53 @@ func checkRepoExist(req *http.Request) bool {
 	return repo.Exist(repoId)
 }
 
-func checkUserRepoAccess(req *http.Request) bool {
+func checkUserRepoAccess(req *http.Request) (bool, []string) {
 	username, password, ok := req.BasicAuth()
 	if !ok {
-		return false
+		return false, nil
 	}
 	vars := mux.Vars(req)
 	repoId := getRepositoryId(vars["organization"], vars["repository"])
 	service := vars["service"]
 
 	deploymentKey := ""
-	if len(username) >= deploymentKeyMinHexLen {
+	if isDeploymentKey(username) {
 		deploymentKey = username
-	} else if len(password) >= deploymentKeyMinHexLen {
+	} else if isDeploymentKey(password) {
 		deploymentKey = password
 	}
 
 	hasAccess := false
+	var userId string
 
-	if deploymentKey != "" {
+	token := ""
+	if tokens.IsToken(password) {
+		token = password
+	} else if tokens.IsToken(username) {
+		token = username
+	}
+
+	if token != "" {
+		var err error
+		userId, err = tokens.Check(token, repoId, service == "git-receive-pack")
+		if err == nil {
+			hasAccess, err = repo.Access(repoId, service, []string{userId})
+		}
+		if err != nil && !hasAccess {
+			log.Printf("No %s access to `%s` for personal access token `%s` user `%s`: %v",
+				service, repoId, tokens.Id(token), userId, err)
+			return false, nil
+		}
+	} else if deploymentKey != "" {
 		decodedUsername, decodedSubject, decodeErr := decodeDeploymentKey(deploymentKey)
 		var accessErr error
 		hasAccess, accessErr = repo.Access(repoId, service, []string{decodedUsername})
 		if decodeErr != nil || (accessErr != nil && !hasAccess) {
-			log.Printf("No %s access to `%s` for token `%s...` user `%s`: %v",
-				service, repoId, deploymentKey[0:8], decodedUsername, seeErrors2(decodeErr, accessErr))
-			return false
+			log.Printf("No %s access to `%s` for token `%s` user `%s`: %v",
+				service, repoId, deploymentKeyHint(deploymentKey), decodedUsername, seeErrors2(decodeErr, accessErr))
+			return false, nil
 		}
+		userId = decodedUsername
 		if hasAccess && decodedSubject != "" {
 			hasAccess = false
 			subjectPrefix := "git:"
@@ -77,20 +99,24 @@ func checkUserRepoAccess(req *http.Request) bool {
 		}
 	} else {
 		var err error
-		hasAccess, err = repo.AccessWithLogin(vars["organization"], repoId, service, username, password)
+		hasAccess, userId, err = repo.AccessWithLogin(vars["organization"], repoId, service, username, password)
 		if err != nil {
 			log.Printf("No %s access to `%s` for user `%s`: %v", service, repoId, username, err)
-			return false
+			return false, nil
 		}
 	}
 
-	return hasAccess
+	if !hasAccess {
+		return false, nil
+	}
+	return true, []string{userId}
 }
 
 func refsInfo(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	repoId := getRepositoryId(vars["organization"], vars["repository"])
 	service := vars["service"]
+	protocol := gitProtocol(req)
 
 	if config.Verbose {
 		log.Printf("Repo `%s` %s refs", repoId, service)
@@ -99,15 +125,31 @@ func refsInfo(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(gitRpcPacket(fmt.Sprintf("# service=%s\n", service))))
-	w.Write([]byte("0000"))
+	// protocol v2 capability advertisement is not prefixed by service announcement,
+	// push is always v0 and so is native Git server
+	if !(repo.ProtocolVersion(protocol) == 2 && service == "git-upload-pack" && !config.NativeGit) {
+		w.Write([]byte(gitRpcPacket(fmt.Sprintf("# service=%s\n", service))))
+		w.Write([]byte("0000"))
+	}
 
-	err := InfoPack(repoId, service, w)
+	err := InfoPack(repoId, service, protocol, w)
 	if err != nil {
 		log.Printf("Got error from Git while %s repo `%s` refs: %v", service, repoId, err)
 	}
 }
 
+var gitProtocolParams = regexp.MustCompile("^[a-zA-Z0-9=:._-]*$")
+
+// gitProtocol returns Git-Protocol header value if it looks sane
+func gitProtocol(req *http.Request) string {
+	protocol := req.Header.Get("Git-Protocol")
+	if !gitProtocolParams.MatchString(protocol) {
+		log.Printf("Ignoring bad Git-Protocol header %q", protocol)
+		return ""
+	}
+	return protocol
+}
+
 func gitRpcPacket(str string) string {
 	s := strconv.FormatInt(int64(len(str)+4), 16)
 	off := len(s) % 4
@@ -129,7 +171,7 @@ func pack(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
 	w.WriteHeader(http.StatusOK)
 
-	err := repo.Pack(repoId, service, w, req.Body)
+	err := repo.Pack(repoId, service, gitProtocol(req), authUsers(req), w, req.Body)
 	if err != nil {
 		log.Printf("Got error from Git while %s repo `%s` pack: %v", service, repoId, err)
 	}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
 	"github.com/agilestacks/git-service/cmd/gits/util"
 )
 
@@ -55,18 +57,32 @@ func withApiSecret(handler http.Handler) http.Handler {
 	})
 }
 
+type contextKey string
+
+const usersKey = contextKey("users")
+
 func withAuth(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		if !checkApiSecretOrUserAuth(req) {
+		ok, users := checkApiSecretOrUserAuth(req)
+		if !ok {
 			rw.Header().Set("WWW-Authenticate", "Basic realm=\".\"")
 			rw.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		if users != nil {
+			req = req.WithContext(context.WithValue(req.Context(), usersKey, users))
+		}
 		handler.ServeHTTP(rw, req)
 	})
 }
 
+// authUsers returns users authenticated by withAuth, nil if API secret was presented
+func authUsers(req *http.Request) []string {
+	users, _ := req.Context().Value(usersKey).([]string)
+	return users
+}
+
 func withRepoExist(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		if !checkRepoExist(req) {
@@ -78,6 +94,34 @@ func withRepoExist(handler http.Handler) http.Handler {
 	})
 }
 
+// withRepoRedirect sends Git client to the new location of moved repository; must follow withAuth
+// so that the new location is revealed only to users with access to the old one
+func withRepoRedirect(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !checkRepoExist(req) {
+			vars := mux.Vars(req)
+			repoId := getRepositoryId(vars["organization"], vars["repository"])
+			if moved, ok := repo.Redirected(repoId); ok {
+				prefix := "/repo/" + vars["organization"] + "/" + vars["repository"]
+				if strings.HasPrefix(req.URL.Path, prefix) {
+					location := "/repo/" + moved
+					if strings.HasSuffix(vars["repository"], ".git") {
+						location += ".git"
+					}
+					location += strings.TrimPrefix(req.URL.Path, prefix)
+					if req.URL.RawQuery != "" {
+						location += "?" + req.URL.RawQuery
+					}
+					http.Redirect(rw, req, location, http.StatusMovedPermanently)
+					return
+				}
+			}
+		}
+
+		handler.ServeHTTP(rw, req)
+	})
+}
+
 func withAllowedGitService(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		if !checkGitService(req) {
@@ -125,27 +169,70 @@ func getRouter() http.Handler {
 		rw.WriteHeader(http.StatusNotFound)
 	}))
 
+	r.Handle("/api/v1/repositories", mw(withLogger, withApiSecret)(http.HandlerFunc(sendRepoList))).
+		Methods("GET")
+	r.Handle("/api/v1/repositories/{organization}", mw(withLogger, withApiSecret)(http.HandlerFunc(sendRepoList))).
+		Methods("GET")
+
+	r.Handle("/api/v1/trash", mw(withLogger, withApiSecret)(http.HandlerFunc(sendTrash))).
+		Methods("GET")
+
+	r.Handle("/api/v1/cache", mw(withLogger, withApiSecret)(http.HandlerFunc(invalidateCache))).
+		Methods("DELETE")
+
+	r.Handle("/api/v1/tokens", mw(withLogger, withApiSecret)(http.HandlerFunc(createToken))).
+		Methods("POST")
+	r.Handle("/api/v1/tokens", mw(withLogger, withApiSecret)(http.HandlerFunc(sendTokens))).
+		Methods("GET")
+	r.Handle("/api/v1/tokens/{id}", mw(withLogger, withApiSecret)(http.HandlerFunc(revokeToken))).
+		Methods("DELETE")
+
+	r.Handle("/api/v1/deployment-keys", mw(withLogger, withApiSecret)(http.HandlerFunc(issueDeploymentKey))).
+		Methods("POST")
+
 	s := r.PathPrefix("/api/v1/repositories/{organization}/{repository}").Subrouter()
 	cmw := mw(withLogger, withApiSecret, withRepoExist)
 	s.Handle("", mw(withLogger, withApiSecret, rejectIfMaintenance)(http.HandlerFunc(createRepo))).
 		Methods("PUT")
 	s.Handle("", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(deleteRepo))).
 		Methods("DELETE")
+	s.Handle("/restore", mw(withLogger, withApiSecret, rejectIfMaintenance)(http.HandlerFunc(restoreRepo))).
+		Methods("POST")
 	s.Handle("/commit/{file:.*}", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(uploadFile))).
 		Methods("PUT")
 	s.Handle("/commit", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(uploadFiles))).
 		Methods("POST")
 	s.Handle("/subtrees", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(addSubtrees))).
 		Methods("POST")
+	s.Handle("/move", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(moveRepo))).
+		Methods("POST")
 	s.Handle("/blob/{file:.*}", cmw(http.HandlerFunc(sendRepoBlob))).
 		Methods("GET")
+	s.Handle("/tree", cmw(http.HandlerFunc(sendRepoTree))).
+		Methods("GET")
+	s.Handle("/tree/{path:.*}", cmw(http.HandlerFunc(sendRepoTree))).
+		Methods("GET")
+	s.Handle("/archive/{archive:.+}", mw(withLogger, withAuth, withRepoExist)(http.HandlerFunc(sendRepoArchive))).
+		Methods("GET")
+	s.Handle("/bundle", cmw(http.HandlerFunc(sendRepoBundle))).
+		Methods("GET")
 	s.Handle("/log", cmw(http.HandlerFunc(sendRepoLog))).
 		Methods("GET")
+	s.Handle("/compare/{spec:.+}", cmw(http.HandlerFunc(sendRepoCompare))).
+		Methods("GET")
+	s.Handle("/commits/{sha}", cmw(http.HandlerFunc(sendRepoCommit))).
+		Methods("GET")
 	s.Handle("/status", cmw(http.HandlerFunc(sendRepoStatus))).
 		Methods("GET")
+	s.Handle("/refs", cmw(http.HandlerFunc(sendRepoRefs))).
+		Methods("GET")
+	s.Handle("/protection", cmw(http.HandlerFunc(sendRepoProtection))).
+		Methods("GET")
+	s.Handle("/protection", mw(cmw, rejectIfMaintenance)(http.HandlerFunc(setRepoProtection))).
+		Methods("PUT")
 
 	s = r.PathPrefix("/repo/{organization}/{repository}").Subrouter()
-	cmw = mw(withLogger, withAuth, withAllowedGitService, withRepoExist)
+	cmw = mw(withLogger, withAuth, withRepoRedirect, withAllowedGitService, withRepoExist)
 	s.Path("/info/refs").Queries("service", "{service}").
 		Methods("GET").
 		Handler(cmw(http.HandlerFunc(refsInfo)))
@@ -153,6 +240,17 @@ func getRouter() http.Handler {
 		Methods("POST").
 		Handler(mw(cmw, rejectIfMaintenance, gunzip)(http.HandlerFunc(pack)))
 
+	s = r.PathPrefix("/api/v1/webhooks/{organization}").Subrouter()
+	cmw = mw(withLogger, withApiSecret)
+	s.Handle("", cmw(http.HandlerFunc(sendWebhook))).
+		Methods("GET")
+	s.Handle("", cmw(http.HandlerFunc(setWebhook))).
+		Methods("PUT")
+	s.Handle("", cmw(http.HandlerFunc(deleteWebhook))).
+		Methods("DELETE")
+	s.Handle("/deliveries", cmw(http.HandlerFunc(sendWebhookDeliveries))).
+		Methods("GET")
+
 	s = r.PathPrefix("/api/v1/ping").Subrouter()
 	s.Handle("", mw(withLogger)(http.HandlerFunc(ping))).
 		Methods("GET")
@@ -231,9 +329,9 @@ func checkApiSecret(req *http.Request) bool {
 	return false
 }
 
-func checkApiSecretOrUserAuth(req *http.Request) bool {
+func checkApiSecretOrUserAuth(req *http.Request) (bool, []string) {
 	if checkApiSecret(req) {
-		return true
+		return true, nil
 	}
 	return checkUserRepoAccess(req)
 }
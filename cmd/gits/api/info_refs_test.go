@@ -4,20 +4,29 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
 )
 
 func init() {
 	config.GitApiSecret = "secret1213"
 	// Mock
-	InfoPack = func(repoId, service string, out io.Writer) error {
+	InfoPack = func(repoId, service, protocol string, out io.Writer) error {
 		return nil
 	}
 }
 
 func testBasicAuth(username, password string, t *testing.T) *httptest.ResponseRecorder {
+	return testInfoRefs(username, password, "", t)
+}
+
+func testInfoRefs(username, password, protocol string, t *testing.T) *httptest.ResponseRecorder {
 	r := getRouter()
 
 	const url = "/repo/X/Y/info/refs?service=git-upload-pack"
@@ -27,6 +36,9 @@ func testBasicAuth(username, password string, t *testing.T) *httptest.ResponseRe
 	}
 
 	req.SetBasicAuth(username, password)
+	if protocol != "" {
+		req.Header.Set("Git-Protocol", protocol)
+	}
 
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
@@ -67,3 +79,52 @@ func TestBasicAuthFailsForBadCredentials(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
+
+func TestBasicAuthFailsForMalformedTokenOrKey(t *testing.T) {
+	for _, password := range []string{"gpat_", "gpat_x", "gpat_0123456789abcdef", "gdk2_", "gdk2_x", "gdk2_0123abcd_AAAA",
+		"gdk2_0123abcd_" + strings.Repeat("A", 40)} {
+		rr := testBasicAuth("random", password, t)
+
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code for `%s`: got %v want %v", password, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestProtocolV2HasNoServiceAnnouncement(t *testing.T) {
+	rr := testInfoRefs("secret1213", "", "version=2", t)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if rr.Body.String() != "" {
+		t.Errorf("handler returned unexpected body: got %v want empty body", rr.Body.String())
+	}
+}
+
+func TestRedirectRequiresAuth(t *testing.T) {
+	os.MkdirAll(filepath.Join(config.RepoDir, "x", "old"), 0755)
+	config.RedirectTtl = time.Hour
+	err := repo.Move("x/old", "x/new", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := getRouter()
+
+	req, _ := http.NewRequest("GET", "/repo/x/old.git/info/refs?service=git-upload-pack", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Location") != "" {
+		t.Errorf("Expected unauthenticated request to be denied without redirect, got %v to %q",
+			rr.Code, rr.Header().Get("Location"))
+	}
+
+	req.SetBasicAuth("secret1213", "")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	expected := "/repo/x/new.git/info/refs?service=git-upload-pack"
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != expected {
+		t.Errorf("Expected redirect to %q, got %v to %q", expected, rr.Code, rr.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+const (
+	listLimitDefault = 100
+	listLimitMax     = 1000
+)
+
+// queryInt returns query parameter value or default if not set
+func queryInt(req *http.Request, param string, def, min, max int) (int, error) {
+	value := req.URL.Query().Get(param)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < min || i > max {
+		return 0, fmt.Errorf("`%s` must be an integer between %d and %d", param, min, max)
+	}
+	return i, nil
+}
+
+func sendRepoList(w http.ResponseWriter, req *http.Request) {
+	org := ""
+	if vars := mux.Vars(req); vars["organization"] != "" {
+		org = sanitize(vars["organization"])
+	}
+
+	offset, err := queryInt(req, "offset", 0, 0, int(^uint(0)>>1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(req, "limit", listLimitDefault, 1, listLimitMax)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := repo.List(org, offset, limit)
+	if err != nil {
+		message := fmt.Sprintf("Unable to list Git repos: %v", err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending %d repos of %d", len(list.Repositories), list.Total)
+	}
+	writeJson(w, list)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func testRepoList(url string, t *testing.T) (*httptest.ResponseRecorder, *repo.RepoList) {
+	r := getRouter()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Secret", config.GitApiSecret)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var list repo.RepoList
+	if rr.Code == http.StatusOK {
+		err = json.Unmarshal(rr.Body.Bytes(), &list)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr, &list
+}
+
+func TestRepoListIsPaged(t *testing.T) {
+	for _, repoId := range []string{"list/b-2", "list/a-1"} {
+		err := exec.Command("git", "init", "-q", "--bare", filepath.Join(config.RepoDir, repoId)).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.RemoveAll(filepath.Join(config.RepoDir, "list"))
+
+	rr, list := testRepoList("/api/v1/repositories/List?offset=1&limit=1", t)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if list.Total != 2 || len(list.Repositories) != 1 {
+		t.Fatalf("handler returned unexpected list: got %+v", list)
+	}
+	if info := list.Repositories[0]; info.Id != "list/b-2" || info.TemplateId != "2" || info.Size == 0 {
+		t.Errorf("handler returned unexpected repo: got %+v", info)
+	}
+
+	rr, list = testRepoList("/api/v1/repositories", t)
+	if list.Total != 2 {
+		t.Errorf("handler returned unexpected list: got %+v", list)
+	}
+
+	rr, _ = testRepoList("/api/v1/repositories?limit=0", t)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestRepoListExcludesTrash(t *testing.T) {
+	for _, repoId := range []string{"list/a-1", "_trash/list/c-3.1600000000"} {
+		err := exec.Command("git", "init", "-q", "--bare", filepath.Join(config.RepoDir, repoId)).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.RemoveAll(filepath.Join(config.RepoDir, "list"))
+	defer os.RemoveAll(filepath.Join(config.RepoDir, "_trash"))
+
+	for _, url := range []string{"/api/v1/repositories", "/api/v1/repositories/list"} {
+		_, list := testRepoList(url, t)
+		if list.Total != 1 || len(list.Repositories) != 1 || list.Repositories[0].Id != "list/a-1" {
+			t.Errorf("handler returned trashed repo in %s: got %+v", url, list)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -11,17 +12,74 @@ import (
 	"github.com/agilestacks/git-service/cmd/gits/repo"
 )
 
+const (
+	logLimitDefault = 100
+	logLimitMax     = 1000
+)
+
+func wantJson(req *http.Request) bool {
+	format := req.URL.Query().Get("format")
+	if format != "" {
+		return format == "json"
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+func logErrorStatus(err error) int {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "not found") {
+		status = http.StatusNotFound
+	} else if strings.Contains(err.Error(), "not supported") {
+		status = http.StatusBadRequest
+	}
+	return status
+}
+
 func sendRepoLog(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	repoId := getRepositoryId(vars["organization"], vars["repository"])
 
-	ref := req.URL.Query().Get("ref")
+	query := req.URL.Query()
+	ref := query.Get("ref")
+	asJson := wantJson(req)
+
+	limitDefault := 0
+	if asJson {
+		limitDefault = logLimitDefault
+	}
+	limit, err := queryInt(req, "limit", limitDefault, 1, logLimitMax)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	skip, err := queryInt(req, "skip", 0, 0, int(^uint(0)>>1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := &repo.LogOptions{Limit: limit, Skip: skip, After: query.Get("after"),
+		Since: query.Get("since"), Path: query.Get("path")}
+
+	if asJson {
+		entries, err := repo.LogEntries(repoId, ref, opts)
+		if err != nil {
+			message := fmt.Sprintf("Unable to obtain Git repo `%s` log: %v", repoId, err)
+			log.Print(message)
+			writeError(w, logErrorStatus(err), message)
+			return
+		}
+		if config.Verbose {
+			log.Printf("Sending repo `%s` log", repoId)
+		}
+		writeJson(w, entries)
+		return
+	}
 
-	logBytes, err := repo.Log(repoId, ref)
+	logBytes, err := repo.Log(repoId, ref, opts)
 	if err != nil {
 		message := fmt.Sprintf("Unable to obtain Git repo `%s` log: %v", repoId, err)
 		log.Print(message)
-		writeError(w, http.StatusInternalServerError, message)
+		writeError(w, logErrorStatus(err), message)
 	} else {
 		if config.Verbose {
 			log.Printf("Sending repo `%s` log", repoId)
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+type MoveRequest struct {
+	To       string `json:"to"`       // organization/repository
+	Redirect bool   `json:"redirect"` // keep old id working for -redirect_ttl
+}
+
+type MoveResponse struct {
+	Id string `json:"id"`
+}
+
+func moveRepo(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	var moveReq MoveRequest
+	err = json.Unmarshal(body, &moveReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling JSON request: %v", err))
+		return
+	}
+	parts := strings.Split(moveReq.To, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Expected `organization/repository`, got `%s`", moveReq.To))
+		return
+	}
+	newId := getRepositoryId(parts[0], parts[1])
+
+	err = repo.Move(repoId, newId, moveReq.Redirect)
+	if err != nil {
+		message := fmt.Sprintf("Unable to move Git repo `%s` to `%s`: %v", repoId, newId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "exist") {
+			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	writeJson(w, MoveResponse{Id: newId})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func sendRepoProtection(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	protection, err := repo.GetProtection(repoId)
+	if err != nil {
+		message := fmt.Sprintf("Unable to obtain Git repo `%s` protected refs: %v", repoId, err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	protectionBytes, err := json.Marshal(protection)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to marshall JSON: %v", err))
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending repo `%s` protected refs", repoId)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(protectionBytes)
+}
+
+func setRepoProtection(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	var protection repo.Protection
+	err = json.Unmarshal(body, &protection)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling JSON request: %v", err))
+		return
+	}
+	if protection.Refs == nil {
+		protection.Refs = []repo.RefProtection{}
+	}
+
+	err = repo.SetProtection(repoId, &protection)
+	if err != nil {
+		message := fmt.Sprintf("Unable to set Git repo `%s` protected refs: %v", repoId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "not set") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+	} else {
+		if config.Verbose {
+			log.Printf("Repo `%s` protected refs set to %+v", repoId, protection.Refs)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func sendRepoRefs(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	query := req.URL.Query()
+	refs, err := repo.Refs(repoId, query.Get("type"), query.Get("prefix"))
+	if err != nil {
+		message := fmt.Sprintf("Unable to obtain Git repo `%s` refs: %v", repoId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending repo `%s` refs", repoId)
+	}
+	writeJson(w, refs)
+}
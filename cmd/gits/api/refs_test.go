@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func TestRefs(t *testing.T) {
+	work, dir := testRepo(t, "refs/refs-1", map[string]string{"README": "readme\n"})
+	master := gitCommand(t, work, "rev-parse", "master")
+	gitCommand(t, work, "branch", "release/1.0")
+	gitCommand(t, work, "tag", "v1")
+	gitCommand(t, work, "tag", "-a", "-m", "Release 1.1", "release/1.1")
+	gitCommand(t, work, "tag", "tree", "master^{tree}")
+	gitCommand(t, work, "push", "-q", dir, "--all")
+	gitCommand(t, work, "push", "-q", dir, "--tags")
+
+	refs := func(query string) []repo.RepoStatus {
+		t.Helper()
+		rr := testGet(t, "/api/v1/repositories/refs/refs-1/refs"+query, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %v: %s", rr.Code, rr.Body.String())
+		}
+		var refs []repo.RepoStatus
+		err := json.Unmarshal(rr.Body.Bytes(), &refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return refs
+	}
+	names := func(refs []repo.RepoStatus) string {
+		names := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			names = append(names, ref.Ref)
+		}
+		return strings.Join(names, " ")
+	}
+
+	all := refs("")
+	if names(all) != "refs/heads/master refs/heads/release/1.0 refs/tags/release/1.1 refs/tags/tree refs/tags/v1" {
+		t.Errorf("Unexpected refs %v", names(all))
+	}
+	for _, ref := range all {
+		// annotated tag is peeled to commit, tag of a tree has no commit
+		if (ref.Ref == "refs/tags/tree") != (ref.Commit == "") ||
+			(ref.Commit != "" && (ref.Commit != master || ref.Subject != "Initial" || ref.Date == "")) {
+			t.Errorf("Unexpected ref %+v", ref)
+		}
+	}
+
+	for query, expected := range map[string]string{
+		"?type=heads":                   "refs/heads/master refs/heads/release/1.0",
+		"?type=tags":                    "refs/tags/release/1.1 refs/tags/tree refs/tags/v1",
+		"?prefix=release/":              "refs/heads/release/1.0 refs/tags/release/1.1",
+		"?type=tags&prefix=release":     "refs/tags/release/1.1",
+		"?prefix=refs/heads/":           "refs/heads/master refs/heads/release/1.0",
+		"?type=heads&prefix=refs/tags/": "",
+	} {
+		if found := names(refs(query)); found != expected {
+			t.Errorf("Expected `%s` for %s, got `%s`", expected, query, found)
+		}
+	}
+
+	rr := testGet(t, "/api/v1/repositories/refs/refs-1/refs?type=remotes", nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown type, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 type SubtreesRequest struct {
 	Subtrees []repo.AddSubtree
+	repo.CommitIdentity
 }
 
 func addSubtrees(w http.ResponseWriter, req *http.Request) {
@@ -41,13 +42,32 @@ func addSubtrees(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repo.AddSubtrees(repoId, branch, reqData.Subtrees)
+	// identity in request body takes precedence over query parameters
+	identity := queryIdentity(req)
+	fromBody := reqData.CommitIdentity
+	for _, field := range []struct{ from, to *string }{
+		{&fromBody.AuthorName, &identity.AuthorName},
+		{&fromBody.AuthorEmail, &identity.AuthorEmail},
+		{&fromBody.CommitterName, &identity.CommitterName},
+		{&fromBody.CommitterEmail, &identity.CommitterEmail},
+		{&fromBody.Date, &identity.Date},
+	} {
+		if *field.from != "" {
+			*field.to = *field.from
+		}
+	}
+
+	err = repo.AddSubtrees(repoId, branch, reqData.Subtrees, identity)
 	if err != nil {
 		message := fmt.Sprintf("Unable to add subtrees to Git repo `%s`: %v", repoId, err)
 		log.Print(message)
 		status := http.StatusInternalServerError
 		if strings.Contains(err.Error(), "exist") {
 			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
 		}
 		writeError(w, status, message)
 	} else {
@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"strings"
 
 	"golang.org/x/crypto/pbkdf2"
 
@@ -20,6 +21,9 @@ const (
 	macLen         = sha1.Size
 )
 
+// returned by decoders on error
+const noUserId = "user-id-that-wont-match-anything"
+
 var (
 	// 2 hex encoding chars per byte
 	// 1 block for iv + 2 blocks of data + mac
@@ -35,16 +39,42 @@ var (
 )
 
 func Init() {
-	if (config.GitApiSecret == "" || config.HubApiSecret == "") && config.Verbose {
-		log.Print("Either Git or Hub API secret is not set - user's deployment keys won't work")
-		return
+	if config.GitApiSecret == "" || config.HubApiSecret == "" {
+		if len(config.DeploymentKeySecrets) == 0 && config.Verbose {
+			log.Print("Either Git or Hub API secret is not set - user's deployment keys won't work")
+		}
+	} else {
+		deploymentKeySecret = []byte(fmt.Sprintf("%s|%s", config.HubApiSecret, config.GitApiSecret))
+		deploymentKeyEncryptionKey = pbkdf2.Key(deploymentKeySecret, deploymentKeySalt, 4096, 32, deploymentKeyMacAlg)
+	}
+	err := initDeploymentKeysV2()
+	if err != nil {
+		log.Fatalf("Unable to initialize deployment keys: %v", err)
+	}
+}
+
+func isDeploymentKey(str string) bool {
+	return len(str) >= deploymentKeyMinHexLen ||
+		(strings.HasPrefix(str, deploymentKeyV2Prefix) && len(str) >= deploymentKeyV2MinLen)
+}
+
+// deploymentKeyHint returns the start of the key for logs
+func deploymentKeyHint(deploymentKey string) string {
+	if len(deploymentKey) > 8 {
+		return deploymentKey[:8] + "..."
+	}
+	return deploymentKey
+}
+
+func decodeDeploymentKey(deploymentKey string) (string, string, error) {
+	if strings.HasPrefix(deploymentKey, deploymentKeyV2Prefix) {
+		return decodeDeploymentKeyV2(deploymentKey)
 	}
-	deploymentKeySecret = []byte(fmt.Sprintf("%s|%s", config.HubApiSecret, config.GitApiSecret))
-	deploymentKeyEncryptionKey = pbkdf2.Key(deploymentKeySecret, deploymentKeySalt, 4096, 32, deploymentKeyMacAlg)
+	return decodeDeploymentKeyV1(deploymentKey)
 }
 
-func decodeDeploymentKey(deploymentKeyHex string) (string, string, error) {
-	userId := "user-id-that-wont-match-anything"
+func decodeDeploymentKeyV1(deploymentKeyHex string) (string, string, error) {
+	userId := noUserId
 	subject := ""
 
 	hexLen := len(deploymentKeyHex)
@@ -69,9 +99,8 @@ func decodeDeploymentKey(deploymentKeyHex string) (string, string, error) {
 	h := hmac.New(deploymentKeyMacAlg, deploymentKeySecret)
 	h.Write(encryptedMaterial)
 	expectedMac := h.Sum(nil)
-	macErr := fmt.Errorf("Bad MAC: expected %v; got %v", expectedMac, mac)
-	if hmac.Equal(expectedMac, mac) {
-		macErr = nil
+	if !hmac.Equal(expectedMac, mac) {
+		return userId, subject, fmt.Errorf("Bad MAC")
 	}
 
 	block, err := aes.NewCipher(deploymentKeyEncryptionKey)
@@ -83,7 +112,7 @@ func decodeDeploymentKey(deploymentKeyHex string) (string, string, error) {
 	decrypter.CryptBlocks(paddedMaterial, encryptedMaterial)
 
 	i := bytes.Index(paddedMaterial, deploymentKeySep)
-	if i > 0 && macErr == nil {
+	if i > 0 {
 		userId = string(paddedMaterial[:i])
 		if i < len(paddedMaterial) {
 			rest := paddedMaterial[i:]
@@ -94,5 +123,5 @@ func decodeDeploymentKey(deploymentKeyHex string) (string, string, error) {
 		}
 	}
 
-	return userId, subject, macErr
+	return userId, subject, nil
 }
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func initDeploymentKeys(t *testing.T, secrets ...string) {
+	config.HubApiSecret = "hubsecret"
+	config.DeploymentKeySecrets = secrets
+	Init()
+	if len(deploymentKeysV2) == 0 {
+		t.Fatal("Deployment keys are not initialized")
+	}
+}
+
+// encodeDeploymentKeyV1 is Hub side of legacy deployment key
+func encodeDeploymentKeyV1(material string) string {
+	padded := make([]byte, 3*cypherBlockLen)
+	copy(padded, material)
+	block, _ := aes.NewCipher(deploymentKeyEncryptionKey)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, deploymentKeyIV).CryptBlocks(encrypted, padded)
+	h := hmac.New(deploymentKeyMacAlg, deploymentKeySecret)
+	h.Write(encrypted)
+	return hex.EncodeToString(append(h.Sum(nil), encrypted...))
+}
+
+func TestDeploymentKeyV1(t *testing.T) {
+	initDeploymentKeys(t)
+
+	key := encodeDeploymentKeyV1("alice|")
+	if !isDeploymentKey(key) {
+		t.Fatalf("Expected `%s` to be deployment key", key)
+	}
+	userId, _, err := decodeDeploymentKey(key)
+	if err != nil || userId != "alice" {
+		t.Errorf("Expected user `alice`, got `%s`: %v", userId, err)
+	}
+
+	// flip a bit of the ciphertext
+	tampered := []byte(key)
+	tampered[len(tampered)-1] ^= 1
+	userId, _, err = decodeDeploymentKey(string(tampered))
+	if err == nil || userId != noUserId {
+		t.Errorf("Expected tampered key to be rejected, got `%s`: %v", userId, err)
+	}
+}
+
+func TestDeploymentKeyV2(t *testing.T) {
+	initDeploymentKeys(t, "old-secret")
+	now := time.Now().Unix()
+	oldKey, oldId, err := issueDeploymentKeyV2(&DeploymentKeyClaims{UserId: "alice", Subject: "git:2", Issued: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(oldKey, deploymentKeyV2Prefix+oldId+"_") || !isDeploymentKey(oldKey) {
+		t.Errorf("Unexpected deployment key format `%s`", oldKey)
+	}
+	expired, _, err := issueDeploymentKeyV2(&DeploymentKeyClaims{UserId: "alice", Issued: now - 10, Expires: now - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate secret: new keys are issued with new secret, old keys are still accepted
+	initDeploymentKeys(t, "new-secret", "old-secret")
+	newKey, newId, err := issueDeploymentKeyV2(&DeploymentKeyClaims{UserId: "bob", Issued: now, Expires: now + 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newId == oldId {
+		t.Errorf("Expected different key id after rotation, got `%s`", newId)
+	}
+
+	userId, subject, err := decodeDeploymentKey(oldKey)
+	if err != nil || userId != "alice" || subject != "git:2" {
+		t.Errorf("Expected user `alice` subject `git:2`, got `%s` `%s`: %v", userId, subject, err)
+	}
+	userId, _, err = decodeDeploymentKey(newKey)
+	if err != nil || userId != "bob" {
+		t.Errorf("Expected user `bob`, got `%s`: %v", userId, err)
+	}
+
+	rejected := map[string]string{
+		"expired":     expired,
+		"tampered":    newKey[:len(newKey)-2] + "AA",
+		"key id":      strings.Replace(newKey, newId, oldId, 1),
+		"unknown id":  deploymentKeyV2Prefix + "00000000_" + newKey[len(deploymentKeyV2Prefix)+deploymentKeyV2IdLen+1:],
+		"truncated":   deploymentKeyV2Prefix + newId + "_AAAA",
+		"no key part": deploymentKeyV2Prefix + newId,
+	}
+	for name, key := range rejected {
+		userId, _, err := decodeDeploymentKey(key)
+		if err == nil || userId != noUserId {
+			t.Errorf("Expected %s key to be rejected, got `%s`", name, userId)
+		}
+	}
+
+	for _, short := range []string{deploymentKeyV2Prefix, deploymentKeyV2Prefix + "x", deploymentKeyV2Prefix + newId + "_AAAA"} {
+		if isDeploymentKey(short) {
+			t.Errorf("Expected `%s` not to be deployment key", short)
+		}
+		if hint := deploymentKeyHint(short); len(hint) > len(short) {
+			t.Errorf("Unexpected hint `%s` of `%s`", hint, short)
+		}
+	}
+
+	// secret removed
+	initDeploymentKeys(t, "new-secret")
+	if _, _, err := decodeDeploymentKey(oldKey); err == nil {
+		t.Error("Expected key issued with removed secret to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* v2 deployment key is gdk2_<key id>_<base64url(nonce | AES-256-GCM sealed claims)>
+   Key id is derived from the secret, so that secrets could be rotated via -deployment_key_secrets_env:
+   the first secret issues new keys, all secrets are accepted. The encryption key is HKDF-SHA256 of the secret. */
+
+const (
+	deploymentKeyV2Prefix = "gdk2_"
+	deploymentKeyV2IdLen  = 8
+	// GCM nonce and tag, claims are at least a few bytes more
+	deploymentKeyV2MinLen = len(deploymentKeyV2Prefix) + deploymentKeyV2IdLen + 1 + (12+16)*4/3
+)
+
+var (
+	deploymentKeyV2Salt = []byte("gits deployment key")
+	deploymentKeyV2Enc  = base64.RawURLEncoding
+	// first issues new keys
+	deploymentKeysV2 []deploymentKeyV2Secret
+)
+
+type deploymentKeyV2Secret struct {
+	id   string
+	aead cipher.AEAD
+}
+
+type DeploymentKeyClaims struct {
+	UserId  string `json:"u"`
+	Subject string `json:"s,omitempty"`
+	Issued  int64  `json:"iat"`
+	Expires int64  `json:"exp,omitempty"`
+}
+
+func initDeploymentKeysV2() error {
+	secrets := config.DeploymentKeySecrets
+	if len(secrets) == 0 && len(deploymentKeySecret) > 0 {
+		secrets = []string{string(deploymentKeySecret)}
+	}
+	keys := make([]deploymentKeyV2Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret == "" {
+			return fmt.Errorf("Empty deployment key secret")
+		}
+		sum := sha256.Sum256([]byte(secret))
+		id := hex.EncodeToString(sum[:])[:deploymentKeyV2IdLen]
+		key := make([]byte, 32)
+		_, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), deploymentKeyV2Salt, []byte(id)), key)
+		if err != nil {
+			return err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, deploymentKeyV2Secret{id: id, aead: aead})
+	}
+	deploymentKeysV2 = keys
+	return nil
+}
+
+func issueDeploymentKeyV2(claims *DeploymentKeyClaims) (string, string, error) {
+	if len(deploymentKeysV2) == 0 {
+		return "", "", fmt.Errorf("Deployment key encoding not initialized")
+	}
+	plaintext, err := json.Marshal(claims)
+	if err != nil {
+		return "", "", err
+	}
+	secret := deploymentKeysV2[0]
+	header := deploymentKeyV2Prefix + secret.id + "_"
+	nonce := make([]byte, secret.aead.NonceSize(), secret.aead.NonceSize()+len(plaintext)+secret.aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", "", err
+	}
+	sealed := secret.aead.Seal(nonce, nonce, plaintext, []byte(header))
+	return header + deploymentKeyV2Enc.EncodeToString(sealed), secret.id, nil
+}
+
+func decodeDeploymentKeyV2(deploymentKey string) (string, string, error) {
+	userId := noUserId
+	subject := ""
+
+	parts := strings.SplitN(strings.TrimPrefix(deploymentKey, deploymentKeyV2Prefix), "_", 2)
+	if len(parts) != 2 {
+		return userId, subject, fmt.Errorf("Bad deployment key format")
+	}
+	var secret *deploymentKeyV2Secret
+	for i := range deploymentKeysV2 {
+		if deploymentKeysV2[i].id == parts[0] {
+			secret = &deploymentKeysV2[i]
+			break
+		}
+	}
+	if secret == nil {
+		return userId, subject, fmt.Errorf("Deployment key id `%s` not found", parts[0])
+	}
+	sealed, err := deploymentKeyV2Enc.DecodeString(parts[1])
+	if err != nil {
+		return userId, subject, fmt.Errorf("Bad deployment key encoding: %v", err)
+	}
+	if len(sealed) < secret.aead.NonceSize()+secret.aead.Overhead() {
+		return userId, subject, fmt.Errorf("Bad deployment key length %d", len(sealed))
+	}
+	nonce := sealed[:secret.aead.NonceSize()]
+	header := deploymentKeyV2Prefix + secret.id + "_"
+	plaintext, err := secret.aead.Open(nil, nonce, sealed[len(nonce):], []byte(header))
+	if err != nil {
+		return userId, subject, fmt.Errorf("Bad deployment key: %v", err)
+	}
+	var claims DeploymentKeyClaims
+	err = json.Unmarshal(plaintext, &claims)
+	if err != nil {
+		return userId, subject, fmt.Errorf("Bad deployment key claims: %v", err)
+	}
+	if claims.Expires > 0 && time.Now().Unix() >= claims.Expires {
+		return userId, subject, fmt.Errorf("Deployment key expired at %s",
+			time.Unix(claims.Expires, 0).UTC().Format(time.RFC3339))
+	}
+	if claims.UserId == "" {
+		return userId, subject, fmt.Errorf("Deployment key without user id")
+	}
+	return claims.UserId, claims.Subject, nil
+}
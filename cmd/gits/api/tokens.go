@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/tokens"
+)
+
+type CreateTokenResponse struct {
+	tokens.Token
+	Secret string `json:"token"`
+}
+
+func createToken(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	var token tokens.Token
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling JSON request: %v", err))
+		return
+	}
+	for i, repoId := range token.Repositories {
+		if parts := strings.Split(repoId, "/"); len(parts) == 2 {
+			token.Repositories[i] = getRepositoryId(parts[0], parts[1])
+		}
+	}
+	for i, org := range token.Organizations {
+		token.Organizations[i] = sanitize(org)
+	}
+
+	secret, err := tokens.Create(&token)
+	if err != nil {
+		message := fmt.Sprintf("Unable to create personal access token for user `%s`: %v", token.UserId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Personal access token `%s` created for user `%s` with %v scopes", token.Id, token.UserId, token.Scopes)
+	}
+	data, err := json.Marshal(CreateTokenResponse{Token: token, Secret: secret})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to marshall JSON: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+func sendTokens(w http.ResponseWriter, req *http.Request) {
+	list, err := tokens.List(req.URL.Query().Get("user"))
+	if err != nil {
+		message := fmt.Sprintf("Unable to list personal access tokens: %v", err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	writeJson(w, list)
+}
+
+func revokeToken(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	err := tokens.Revoke(id)
+	if err != nil {
+		message := fmt.Sprintf("Unable to revoke personal access token `%s`: %v", id, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, message)
+	} else {
+		if config.Verbose {
+			log.Printf("Personal access token `%s` revoked", id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func sendTrash(w http.ResponseWriter, req *http.Request) {
+	org := req.URL.Query().Get("organization")
+	if org != "" {
+		org = sanitize(org)
+	}
+	trashed, err := repo.Trash(org)
+	if err != nil {
+		message := fmt.Sprintf("Unable to list trash: %v", err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	writeJson(w, trashed)
+}
+
+func restoreRepo(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+
+	var deleted int64
+	if str := req.URL.Query().Get("deleted"); str != "" {
+		var err error
+		deleted, err = strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Bad `deleted` unix time %q: %v", str, err))
+			return
+		}
+	}
+
+	restored, err := repo.Restore(repoId, deleted)
+	if err != nil {
+		message := fmt.Sprintf("Unable to restore Git repo `%s`: %v", repoId, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "exist") {
+			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Repo `%s` restored", repoId)
+	}
+	writeJson(w, restored)
+}
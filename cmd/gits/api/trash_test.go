@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func TestRestoreStatus(t *testing.T) {
+	retention := config.TrashRetention
+	config.TrashRetention = time.Hour
+	defer func() { config.TrashRetention = retention }()
+
+	testRepo(t, "trash/restore-1", nil)
+	err := repo.Delete("trash/restore-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := func(query string) int {
+		req, err := http.NewRequest("POST", "/api/v1/repositories/trash/restore-1/restore"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Secret", config.GitApiSecret)
+		rr := httptest.NewRecorder()
+		getRouter().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, test := range []struct {
+		query  string
+		status int
+	}{
+		{"?deleted=1", http.StatusNotFound},
+		{"?deleted=yesterday", http.StatusBadRequest},
+		{"", http.StatusOK},
+		{"", http.StatusConflict},
+	} {
+		if status := restore(test.query); status != test.status {
+			t.Errorf("Expected %d restoring `%s`, got %d", test.status, test.query, status)
+		}
+	}
+}
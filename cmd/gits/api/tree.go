@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func sendRepoTree(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	repoId := getRepositoryId(vars["organization"], vars["repository"])
+	path := filepath.Clean(vars["path"])
+
+	query := req.URL.Query()
+	ref := query.Get("ref")
+	recursive := query.Get("recursive") == "true" || query.Get("recursive") == "1"
+
+	entries, err := repo.Tree(repoId, ref, path, recursive)
+	if err != nil {
+		message := fmt.Sprintf("Unable to obtain Git repo `%s` tree `%s`: %v", repoId, path, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "not a directory") || strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	if config.Verbose {
+		log.Printf("Sending repo `%s` tree `%s`", repoId, path)
+	}
+	writeJson(w, entries)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func TestTree(t *testing.T) {
+	work, dir := testRepo(t, "tree/tree-1", map[string]string{"README": "readme\n", "docs/guide/intro": "intro\n"})
+	err := os.Symlink("README", filepath.Join(work, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitCommand(t, work, "add", "link")
+	submodule := gitCommand(t, work, "rev-parse", "master")
+	gitCommand(t, work, "update-index", "--add", "--cacheinfo", "160000,"+submodule+",sub")
+	gitCommand(t, work, "commit", "-q", "-m", "Link and submodule")
+	gitCommand(t, work, "push", "-q", dir, "master")
+
+	tree := func(path string) map[string]repo.TreeEntry {
+		t.Helper()
+		rr := testGet(t, "/api/v1/repositories/tree/tree-1/tree"+path, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %v: %s", rr.Code, rr.Body.String())
+		}
+		var entries []repo.TreeEntry
+		err := json.Unmarshal(rr.Body.Bytes(), &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byPath := make(map[string]repo.TreeEntry)
+		for _, entry := range entries {
+			byPath[entry.Path] = entry
+		}
+		return byPath
+	}
+
+	entries := tree("")
+	if len(entries) != 4 {
+		t.Errorf("Unexpected root tree %+v", entries)
+	}
+	for path, expected := range map[string]struct {
+		entryType string
+		mode      string
+		size      int64 // -1 if not set
+	}{
+		"README": {"blob", "100644", 7},
+		"docs":   {"tree", "040000", -1},
+		"link":   {"symlink", "120000", 6},
+		"sub":    {"submodule", "160000", -1},
+	} {
+		entry := entries[path]
+		if entry.Type != expected.entryType || entry.Mode != expected.mode || entry.Name != path ||
+			(expected.size < 0) != (entry.Size == nil) || (entry.Size != nil && *entry.Size != expected.size) {
+			t.Errorf("Unexpected `%s` entry %+v", path, entry)
+		}
+	}
+	if entries["sub"].Id != submodule {
+		t.Errorf("Expected submodule at %s, got %s", submodule, entries["sub"].Id)
+	}
+
+	entries = tree("/docs?recursive=true")
+	if len(entries) != 2 || entries["docs/guide"].Type != "tree" ||
+		entries["docs/guide/intro"].Type != "blob" || entries["docs/guide/intro"].Name != "intro" {
+		t.Errorf("Unexpected recursive tree %+v", entries)
+	}
+	entries = tree("/docs/guide?ref=master~1")
+	if len(entries) != 1 || entries["docs/guide/intro"].Id != gitCommand(t, work, "rev-parse", "master:docs/guide/intro") {
+		t.Errorf("Unexpected tree %+v", entries)
+	}
+
+	for path, status := range map[string]int{
+		"/missing":           http.StatusNotFound,
+		"?ref=missing":       http.StatusNotFound,
+		"/sub/x":             http.StatusNotFound,
+		"/README":            http.StatusBadRequest,
+		"/link":              http.StatusBadRequest,
+		"?ref=--output=x":    http.StatusBadRequest,
+		"/link?ref=master~1": http.StatusNotFound,
+	} {
+		rr := testGet(t, "/api/v1/repositories/tree/tree-1/tree"+path, nil)
+		if rr.Code != status {
+			t.Errorf("Expected %d for `%s`, got %v: %s", status, path, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -22,10 +24,13 @@ func uploadFile(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Printf("Bad file mode: %v", err)
 	}
-	add(repoId, branch,
-		[]repo.AddFile{{Path: filepath.Clean(vars["file"]), Content: req.Body, Mode: mode}},
-		queryCommitMessage(req),
-		w)
+	add(repoId, &repo.AddRequest{
+		Branch:       branch,
+		Files:        []repo.AddFile{{Path: filepath.Clean(vars["file"]), Content: req.Body, Mode: mode}},
+		Message:      queryCommitMessage(req),
+		ExpectedHead: queryExpectedHead(req),
+		Identity:     queryIdentity(req),
+	}, w)
 }
 
 func uploadFiles(w http.ResponseWriter, req *http.Request) {
@@ -87,18 +92,70 @@ func uploadFiles(w http.ResponseWriter, req *http.Request) {
 			break
 		}
 	}
-	add(repoId, branch, files, queryCommitMessage(req), w)
+
+	// `delete` and `move` are regular form fields, `move` is `from:to`
+	deletes := make([]string, 0)
+	for _, path := range req.MultipartForm.Value["delete"] {
+		deletes = append(deletes, filepath.Clean(path))
+	}
+	moves := make([]repo.AddMove, 0)
+	for _, move := range req.MultipartForm.Value["move"] {
+		parts := strings.SplitN(move, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			diagnose(http.StatusBadRequest, fmt.Sprintf("Bad move `%s`, expected `from:to`", move))
+			return
+		}
+		moves = append(moves, repo.AddMove{From: filepath.Clean(parts[0]), To: filepath.Clean(parts[1])})
+	}
+
+	add(repoId, &repo.AddRequest{
+		Branch:       branch,
+		Files:        files,
+		Delete:       deletes,
+		Move:         moves,
+		Message:      queryCommitMessage(req),
+		ExpectedHead: queryExpectedHead(req),
+		Identity:     queryIdentity(req),
+	}, w)
 }
 
-func add(repoId, branch string, files []repo.AddFile, commitMessage string, w http.ResponseWriter) {
-	err := repo.Add(repoId, branch, files, commitMessage)
+type addResult struct {
+	Commit string `json:"commit"`
+}
+
+func add(repoId string, addReq *repo.AddRequest, w http.ResponseWriter) {
+	head, err := repo.Add(repoId, addReq)
 	if err != nil {
 		message := fmt.Sprintf("Unable to add files to Git repository: %v", err)
 		log.Print(message)
-		writeError(w, http.StatusInternalServerError, message)
-	} else {
-		w.WriteHeader(http.StatusNoContent)
+		status := http.StatusInternalServerError
+		var moved *repo.RefMovedError
+		if errors.As(err, &moved) {
+			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "locked") {
+			status = http.StatusLocked
+		} else if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+		return
+	}
+	if head != "" {
+		w.Header().Set("ETag", `"`+head+`"`)
+	}
+	writeJson(w, addResult{Commit: head})
+}
+
+// queryExpectedHead returns commit id the branch is expected to point to, set by
+// `expectedHead` query parameter or `If-Match` header
+func queryExpectedHead(req *http.Request) string {
+	head := req.URL.Query().Get("expectedHead")
+	if head == "" {
+		head = strings.Trim(strings.TrimPrefix(req.Header.Get("If-Match"), "W/"), `"`)
 	}
+	return head
 }
 
 func queryCommitMessage(req *http.Request) string {
@@ -116,3 +173,14 @@ func queryFileMode(req *http.Request) (os.FileMode, error) {
 	}
 	return os.FileMode(mode), nil
 }
+
+func queryIdentity(req *http.Request) *repo.CommitIdentity {
+	query := req.URL.Query()
+	return &repo.CommitIdentity{
+		AuthorName:     query.Get("authorName"),
+		AuthorEmail:    query.Get("authorEmail"),
+		CommitterName:  query.Get("committerName"),
+		CommitterEmail: query.Get("committerEmail"),
+		Date:           query.Get("date"),
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/repo"
+)
+
+func TestAddErrorStatus(t *testing.T) {
+	err := repo.Create("x/upload-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := &repo.CommitIdentity{AuthorName: "Test", AuthorEmail: "test@example.com",
+		CommitterName: "Test", CommitterEmail: "test@example.com"}
+	rr := httptest.NewRecorder()
+	add("x/upload-1", &repo.AddRequest{Files: []repo.AddFile{{Path: "README", Content: strings.NewReader("a")}},
+		Identity: identity}, rr)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %v: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, test := range []struct {
+		req    *repo.AddRequest
+		status int
+	}{
+		// path mentioning `moved` is not a conflict
+		{&repo.AddRequest{Delete: []string{"moved/file"}}, http.StatusNotFound},
+		{&repo.AddRequest{ExpectedHead: strings.Repeat("0", 40), Delete: []string{"README"}}, http.StatusConflict},
+		{&repo.AddRequest{Delete: []string{"../README"}}, http.StatusBadRequest},
+	} {
+		test.req.Identity = identity
+		rr := httptest.NewRecorder()
+		add("x/upload-1", test.req, rr)
+		if rr.Code != test.status {
+			t.Errorf("Expected status %v, got %v: %s", test.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
+)
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to marshall JSON: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func sendWebhook(w http.ResponseWriter, req *http.Request) {
+	org := sanitize(mux.Vars(req)["organization"])
+
+	hook, err := webhooks.Get(org)
+	if err != nil {
+		message := fmt.Sprintf("Unable to obtain organization `%s` webhook: %v", org, err)
+		log.Print(message)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	if hook == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No webhook for organization `%s` found", org))
+		return
+	}
+	// secret is write-only
+	writeJson(w, struct {
+		Url    string `json:"url"`
+		Signed bool   `json:"signed"`
+	}{hook.Url, hook.Secret != ""})
+}
+
+func setWebhook(w http.ResponseWriter, req *http.Request) {
+	org := sanitize(mux.Vars(req)["organization"])
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Error reading request body: %v", err))
+		return
+	}
+	var hook webhooks.Webhook
+	err = json.Unmarshal(body, &hook)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling JSON request: %v", err))
+		return
+	}
+
+	err = webhooks.Set(org, &hook)
+	if err != nil {
+		message := fmt.Sprintf("Unable to set organization `%s` webhook: %v", org, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not supported") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, message)
+	} else {
+		if config.Verbose {
+			log.Printf("Organization `%s` webhook set to %s", org, hook.Url)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteWebhook(w http.ResponseWriter, req *http.Request) {
+	org := sanitize(mux.Vars(req)["organization"])
+
+	err := webhooks.Delete(org)
+	if err != nil {
+		message := fmt.Sprintf("Unable to delete organization `%s` webhook: %v", org, err)
+		log.Print(message)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "No webhook") {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, message)
+	} else {
+		if config.Verbose {
+			log.Printf("Organization `%s` webhook deleted", org)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func sendWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	org := sanitize(mux.Vars(req)["organization"])
+	writeJson(w, webhooks.Deliveries(org))
+}
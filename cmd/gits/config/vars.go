@@ -4,6 +4,7 @@ import (
 	"log"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
@@ -22,16 +23,40 @@ var (
 	SshPort         int
 	HostKeyFile     string
 	BlobsFrom       []string
+	NativeGit       bool
+	CommitName      string
+	CommitEmail     string
+	LockTimeout     time.Duration
+	LockFiles       bool
+	RedirectTtl     time.Duration
+	TrashRetention  time.Duration
 
 	GitApiSecret string
+	TokenTtl     time.Duration
 
-	NoExtApiCalls   bool
-	HubApiSecret    string
-	AuthApiSecret   string
-	SubsApiSecret   string
-	HubApiEndpoint  string
-	AuthApiEndpoint string
-	SubsApiEndpoint string
+	DeploymentKeySecrets []string
+	DeploymentKeyTtl     time.Duration
+
+	AuthProvider   string
+	AuthStaticFile string
+
+	WebhookUrl     string
+	WebhookSecret  string
+	WebhookRetries int
+	WebhookTimeout time.Duration
+
+	NoExtApiCalls          bool
+	ExtApiCacheTtl         time.Duration
+	ExtApiNegativeCacheTtl time.Duration
+	ExtApiCacheStale       time.Duration
+	TeamLookupConcurrency  int
+	TeamLookupTimeout      time.Duration
+	HubApiSecret           string
+	AuthApiSecret          string
+	SubsApiSecret          string
+	HubApiEndpoint         string
+	AuthApiEndpoint        string
+	SubsApiEndpoint        string
 
 	AwsRegion                string
 	AwsProfile               string
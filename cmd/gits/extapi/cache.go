@@ -0,0 +1,173 @@
+package extapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+// notFoundError is upstream 404, cached for -ext_api_negative_cache_ttl
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &notFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	fetched time.Time
+	expires time.Time
+}
+
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// lookupCache keeps external API responses by `kind:id` key,
+// concurrent lookups of the same key wait for a single upstream request
+type lookupCache struct {
+	sync.Mutex
+	entries    map[string]*cacheEntry
+	calls      map[string]*cacheCall
+	generation int
+	lastSweep  time.Time
+}
+
+var lookups = &lookupCache{
+	entries: make(map[string]*cacheEntry),
+	calls:   make(map[string]*cacheCall),
+}
+
+// cached returns value from cache or fetches it; the value is shared by all callers,
+// including concurrent lookups waiting for the same upstream request, and must not be modified
+func cached(kind, id string, fetch func() (interface{}, error)) (interface{}, error) {
+	if config.ExtApiCacheTtl <= 0 {
+		return fetch()
+	}
+	key := kind + ":" + id
+
+	lookups.Lock()
+	entry, exist := lookups.entries[key]
+	if exist && time.Now().Before(entry.expires) {
+		lookups.Unlock()
+		return entry.value, entry.err
+	}
+	call, loading := lookups.calls[key]
+	if loading {
+		lookups.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call = &cacheCall{done: make(chan struct{})}
+	lookups.calls[key] = call
+	generation := lookups.generation
+	lookups.Unlock()
+
+	value, err := fetch()
+
+	lookups.Lock()
+	now := time.Now()
+	var notFoundErr *notFoundError
+	// do not store response that was fetched before invalidation
+	store := generation == lookups.generation
+	if err == nil {
+		if store {
+			lookups.entries[key] = &cacheEntry{value: value, fetched: now, expires: now.Add(config.ExtApiCacheTtl)}
+		}
+	} else if errors.As(err, &notFoundErr) {
+		if store {
+			lookups.entries[key] = &cacheEntry{err: err, fetched: now, expires: now.Add(config.ExtApiNegativeCacheTtl)}
+		}
+	} else if exist && now.Sub(entry.fetched) < config.ExtApiCacheStale {
+		log.Printf("Using stale `%s` lookup fetched %v ago: %v", key, now.Sub(entry.fetched).Truncate(time.Second), err)
+		value, err = entry.value, entry.err
+	}
+	if lookups.calls[key] == call {
+		delete(lookups.calls, key)
+	}
+	lookups.sweep(now)
+	lookups.Unlock()
+
+	call.value, call.err = value, err
+	close(call.done)
+	return value, err
+}
+
+// sweep removes entries that are too old to be served stale, must be called with lock held
+func (c *lookupCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	keep := config.ExtApiCacheStale
+	if keep < config.ExtApiCacheTtl {
+		keep = config.ExtApiCacheTtl
+	}
+	for key, entry := range c.entries {
+		if now.Sub(entry.fetched) > keep && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidateCache drops cached lookups of user (SSH keys, team memberships), team, template, and organization.
+// User invalidation also drops SSH keys not matched to any user, as the key might be just added, and all teams,
+// as members are cached by team and there is no way to tell which team the user might be just added to.
+// With all arguments empty the whole cache is dropped. Returns number of entries removed.
+func InvalidateCache(userId, teamId, templateId, orgId string) int {
+	all := userId == "" && teamId == "" && templateId == "" && orgId == ""
+	keys := make(map[string]bool)
+	if teamId != "" {
+		keys["team:"+teamId] = true
+	}
+	if templateId != "" {
+		keys["template:"+templateId] = true
+	}
+	if orgId != "" {
+		keys["org:"+strings.ToUpper(orgId)] = true
+	}
+
+	lookups.Lock()
+	defer lookups.Unlock()
+	lookups.generation++
+	removed := 0
+	for key, entry := range lookups.entries {
+		drop := all || keys[key]
+		if !drop && userId != "" {
+			if strings.HasPrefix(key, "team:") {
+				drop = true
+			} else if strings.HasPrefix(key, "key:") {
+				users, _ := entry.value.([]string)
+				drop = contains(users, userId) || len(users) == 0
+			}
+		}
+		if drop {
+			delete(lookups.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,148 @@
+package extapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func standIn(down *int32) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(down) != 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch req.URL.Path {
+		case "/teams/1":
+			w.Write([]byte(`{"members": [{"id": "alice", "status": "ACTIVE"}, {"id": "bob", "status": "DEPROVISIONED"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	config.NoExtApiCalls = false
+	config.AuthApiEndpoint = server.URL
+	config.ExtApiCacheTtl = 50 * time.Millisecond
+	config.ExtApiNegativeCacheTtl = time.Minute
+	config.ExtApiCacheStale = time.Minute
+	InvalidateCache("", "", "", "")
+	return server, &requests
+}
+
+func TestCacheTeams(t *testing.T) {
+	var down int32
+	server, requests := standIn(&down)
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		users, err := UsersByTeam("1")
+		if err != nil || len(users) != 1 || users[0] != "alice" {
+			t.Fatalf("Unexpected team members %v: %v", users, err)
+		}
+		_, err = UsersByTeam("2")
+		if err == nil {
+			t.Fatal("Expected team `2` not found")
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("Expected 2 upstream requests, got %d", n)
+	}
+
+	// upstream is down, expired entry is served stale
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(60 * time.Millisecond)
+	users, err := UsersByTeam("1")
+	if err != nil || len(users) != 1 {
+		t.Errorf("Expected stale team members, got %v: %v", users, err)
+	}
+
+	// all teams are dropped, including not found
+	if n := InvalidateCache("alice", "", "", ""); n != 2 {
+		t.Errorf("Expected 2 entries invalidated by user, got %d", n)
+	}
+	_, err = UsersByTeam("1")
+	if err == nil {
+		t.Error("Expected upstream error after invalidation")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	var down int32
+	server, requests := standIn(&down)
+	defer server.Close()
+	config.ExtApiCacheTtl = 0
+
+	UsersByTeam("1")
+	UsersByTeam("1")
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("Expected 2 upstream requests with cache disabled, got %d", n)
+	}
+}
+
+func TestCacheUserAddedToTeam(t *testing.T) {
+	var down int32
+	server, _ := standIn(&down)
+	defer server.Close()
+	var members atomic.Value
+	members.Store(`{"id": "alice", "status": "ACTIVE"}`)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"members": [%s]}`, members.Load())
+	})
+	config.ExtApiCacheTtl = time.Minute
+
+	users, err := UsersByTeam("1")
+	if err != nil || len(users) != 1 {
+		t.Fatalf("Unexpected team members %v: %v", users, err)
+	}
+	members.Store(`{"id": "alice", "status": "ACTIVE"}, {"id": "carol", "status": "ACTIVE"}`)
+	// carol is not in cached team yet, still the team must be dropped
+	if n := InvalidateCache("carol", "", "", ""); n != 1 {
+		t.Errorf("Expected 1 entry invalidated by user, got %d", n)
+	}
+	users, err = UsersByTeam("1")
+	if err != nil || len(users) != 2 || users[1] != "carol" {
+		t.Errorf("Expected carol in team members, got %v: %v", users, err)
+	}
+}
+
+func TestCacheConcurrentLookups(t *testing.T) {
+	var down int32
+	server, requests := standIn(&down)
+	defer server.Close()
+	release := make(chan struct{})
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		handler.ServeHTTP(w, req)
+	})
+	config.ExtApiCacheTtl = time.Minute
+
+	var wg sync.WaitGroup
+	results := make([][]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = UsersByTeam("1")
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("Expected 1 upstream request for concurrent lookups, got %d", n)
+	}
+	for _, users := range results {
+		if len(users) != 1 || users[0] != "alice" {
+			t.Errorf("Unexpected team members %v", users)
+		}
+	}
+}
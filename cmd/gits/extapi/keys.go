@@ -18,6 +18,14 @@ type HubUserKey struct {
 }
 
 func UsersBySshKey(keyBase64 string, keyFingerprintSHA256 string) ([]string, error) {
+	value, err := cached("key", keyFingerprintSHA256, func() (interface{}, error) {
+		return fetchUsersBySshKey(keyBase64, keyFingerprintSHA256)
+	})
+	users, _ := value.([]string)
+	return users, err
+}
+
+func fetchUsersBySshKey(keyBase64 string, keyFingerprintSHA256 string) ([]string, error) {
 	if config.NoExtApiCalls {
 		return agileUsers, nil
 	}
@@ -18,12 +18,19 @@ type Org struct {
 }
 
 func OrgById(orgId string) (*Org, error) {
+	orgId = strings.ToUpper(orgId)
+	value, err := cached("org", orgId, func() (interface{}, error) {
+		return fetchOrgById(orgId)
+	})
+	org, _ := value.(*Org)
+	return org, err
+}
+
+func fetchOrgById(orgId string) (*Org, error) {
 	if config.NoExtApiCalls {
 		return &Org{Id: "ASI", ShowSource: true}, nil
 	}
 
-	orgId = strings.ToUpper(orgId)
-
 	orgs := fmt.Sprintf("%s/organizations/%s", config.SubsApiEndpoint, url.QueryEscape(orgId))
 	req, err := http.NewRequest("GET", orgs, nil)
 	if config.HubApiSecret != "" {
@@ -38,7 +45,7 @@ func OrgById(orgId string) (*Org, error) {
 		log.Printf("%s %s: %s", req.Method, req.URL.String(), resp.Status)
 	}
 	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("No `%s` organization found", orgId)
+		return nil, notFound("No `%s` organization found", orgId)
 	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("Got %d HTTP querying Hub organizations", resp.StatusCode)
@@ -21,11 +21,19 @@ type AuthTeam struct {
 }
 
 func UsersByTeam(teamId string) ([]string, error) {
+	value, err := cached("team", teamId, func() (interface{}, error) {
+		return fetchUsersByTeam(teamId)
+	})
+	users, _ := value.([]string)
+	return users, err
+}
+
+func fetchUsersByTeam(teamId string) ([]string, error) {
 	if config.NoExtApiCalls {
 		if teamId == "1" {
 			return agileUsers, nil
 		}
-		return nil, fmt.Errorf("No `%s` team found", teamId)
+		return nil, notFound("No `%s` team found", teamId)
 	}
 
 	authTeams := fmt.Sprintf("%s/teams/%s", config.AuthApiEndpoint, url.QueryEscape(teamId))
@@ -45,7 +53,7 @@ func UsersByTeam(teamId string) ([]string, error) {
 		log.Printf("%s %s: %s", req.Method, req.URL.String(), resp.Status)
 	}
 	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("No `%s` team found", teamId)
+		return nil, notFound("No `%s` team found", teamId)
 	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("Got %d HTTP querying Auth Service team", resp.StatusCode)
@@ -35,6 +35,14 @@ type Template struct {
 }
 
 func TemplateById(templateId string) (*Template, error) {
+	value, err := cached("template", templateId, func() (interface{}, error) {
+		return fetchTemplateById(templateId)
+	})
+	template, _ := value.(*Template)
+	return template, err
+}
+
+func fetchTemplateById(templateId string) (*Template, error) {
 	if config.NoExtApiCalls {
 		return &Template{OwnerUserId: "arkadi", Teams: []TeamAccess{
 			TeamAccess{TeamId: "1", CanWrite: true},
@@ -55,7 +63,7 @@ func TemplateById(templateId string) (*Template, error) {
 		log.Printf("%s %s: %s", req.Method, req.URL.String(), resp.Status)
 	}
 	if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("No `%s` template found", templateId)
+		return nil, notFound("No `%s` template found", templateId)
 	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("Got %d HTTP querying Hub templates", resp.StatusCode)
@@ -6,13 +6,14 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
 )
 
 func Parse() {
 	var blobsFrom string
-	var apiSecretEnvVar, hubApiSecretEnvVar, authApiSecretEnvVar, subsApiSecretEnvVar string
+	var apiSecretEnvVar, webhookSecretEnvVar, deploymentKeySecretsEnvVar, hubApiSecretEnvVar, authApiSecretEnvVar, subsApiSecretEnvVar string
 	var hubApiEndpoint, hubApiEndpointEnvVar, hubApiHostEnvVar, hubApiPortEnvVar string
 	var authApiEndpoint, authApiEndpointEnvVar, authApiHostEnvVar, authApiPortEnvVar string
 	var subsApiEndpoint, subsApiEndpointEnvVar, subsApiHostEnvVar, subsApiPortEnvVar string
@@ -27,13 +28,36 @@ func Parse() {
 	flag.IntVar(&config.HttpPort, "http_port", 8005, "HTTP API port to listen")
 	flag.IntVar(&config.SshPort, "ssh_port", 2022, "SSH server port to listen")
 	flag.StringVar(&config.HostKeyFile, "host_key", "gits-key", "Path to SSH server host private key file")
+	flag.BoolVar(&config.NativeGit, "native_git", false, "Serve Git pack protocol in-process instead of spawning git-upload-pack / git-receive-pack")
+	flag.StringVar(&config.CommitName, "commit_name", "", "Default author / committer name of API commits, Git config user.name if not set")
+	flag.StringVar(&config.CommitEmail, "commit_email", "", "Default author / committer email of API commits, Git config user.email if not set")
+	flag.DurationVar(&config.LockTimeout, "lock_timeout", 30*time.Second, "Time to wait for concurrent repository update to finish")
+	flag.BoolVar(&config.LockFiles, "lock_files", false, "Also lock repositories with flock(2) on <repo_dir>/_locks to serialize multiple Git Service processes")
+	flag.DurationVar(&config.RedirectTtl, "redirect_ttl", 30*24*time.Hour, "How long moved repository old id redirects to the new one")
+	flag.DurationVar(&config.TrashRetention, "trash_retention", 7*24*time.Hour, "How long deleted repositories are kept in <repo_dir>/_trash, 0 to delete immediately")
 	flag.StringVar(&apiSecretEnvVar, "api_secret_env", "GIT_API_SECRET", "Environment variable to get secret from to protect Git HTTP API")
+	flag.StringVar(&deploymentKeySecretsEnvVar, "deployment_key_secrets_env", "", "Environment variable to get comma separated secrets from to issue (first) and decode v2 deployment keys, default is derived from Git and Hub API secrets")
+	flag.DurationVar(&config.DeploymentKeyTtl, "deployment_key_ttl", 365*24*time.Hour, "Default expiry of v2 deployment keys, 0 for keys that never expire")
+	flag.DurationVar(&config.TokenTtl, "token_ttl", 90*24*time.Hour, "Default expiry of personal access tokens, 0 for tokens that never expire")
+
+	flag.StringVar(&config.WebhookUrl, "webhook_url", "", "URL to POST repository events to, in addition to per organization webhooks")
+	flag.StringVar(&webhookSecretEnvVar, "webhook_secret_env", "", "Environment variable to get secret from to sign -webhook_url events")
+	flag.IntVar(&config.WebhookRetries, "webhook_retries", 3, "Number of webhook delivery retries")
+	flag.DurationVar(&config.WebhookTimeout, "webhook_timeout", 10*time.Second, "Webhook delivery HTTP request timeout")
 
 	flag.StringVar(&hubApiSecretEnvVar, "hub_api_secret_env", "HUB_API_SECRET", "Environment variable to get secret for Automation Hub HTTP API")
 	flag.StringVar(&authApiSecretEnvVar, "auth_api_secret_env", "AUTH_API_SECRET", "Environment variable to get secret for Auth Service HTTP API")
 	flag.StringVar(&subsApiSecretEnvVar, "subs_api_secret_env", "SUBS_API_SECRET", "Environment variable to get secret for Subscriptions Service HTTP API")
 
+	flag.StringVar(&config.AuthProvider, "auth_provider", "hub", "Authorization provider: `hub` for Automation Hub and Auth Service, `static` for -auth_static_file")
+	flag.StringVar(&config.AuthStaticFile, "auth_static_file", "", "YAML file with users, SSH keys, and repository grants for -auth_provider static")
+
 	flag.BoolVar(&config.NoExtApiCalls, "no_ext_api_calls", false, "Emulate external calls to Automation Hub and Auth Service with internal stubs")
+	flag.DurationVar(&config.ExtApiCacheTtl, "ext_api_cache_ttl", time.Minute, "How long to cache users, teams, templates, and organizations fetched from Automation Hub and Auth Service, 0 to disable cache")
+	flag.DurationVar(&config.ExtApiNegativeCacheTtl, "ext_api_negative_cache_ttl", 10*time.Second, "How long to cache not found responses of Automation Hub and Auth Service")
+	flag.DurationVar(&config.ExtApiCacheStale, "ext_api_cache_stale", time.Hour, "How long to serve expired cache entries when Automation Hub or Auth Service is unavailable")
+	flag.IntVar(&config.TeamLookupConcurrency, "team_lookup_concurrency", 8, "Number of template teams members to fetch from Auth Service concurrently")
+	flag.DurationVar(&config.TeamLookupTimeout, "team_lookup_timeout", 10*time.Second, "Deadline to fetch template teams members when checking repository access")
 	flag.StringVar(&hubApiEndpointEnvVar, "hub_api_endpoint_env", "HUB_SERVICE_ENDPOINT", "Environment variable to get Automation Hub HTTP API endpoint")
 	flag.StringVar(&hubApiHostEnvVar, "hub_api_host_env", "HUB_SERVICE_HOST", "Environment variable to get Automation Hub HTTP API hostname / IP")
 	flag.StringVar(&hubApiPortEnvVar, "hub_api_port_env", "HUB_SERVICE_PORT", "Environment variable to get Automation Hub HTTP API port")
@@ -68,6 +92,10 @@ Flags:
 	flag.Parse()
 
 	config.GitApiSecret = lookupEnv(apiSecretEnvVar, "api_secret_env")
+	config.WebhookSecret = lookupEnv(webhookSecretEnvVar, "webhook_secret_env")
+	if secrets := lookupEnv(deploymentKeySecretsEnvVar, "deployment_key_secrets_env"); secrets != "" {
+		config.DeploymentKeySecrets = strings.Split(secrets, ",")
+	}
 	if !config.NoExtApiCalls {
 		config.HubApiSecret = lookupEnv(hubApiSecretEnvVar, "hub_api_secret_env")
 		config.AuthApiSecret = lookupEnv(authApiSecretEnvVar, "auth_api_secret_env")
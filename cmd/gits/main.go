@@ -6,6 +6,7 @@ import (
 	"github.com/agilestacks/git-service/cmd/gits/api"
 	"github.com/agilestacks/git-service/cmd/gits/config"
 	"github.com/agilestacks/git-service/cmd/gits/flags"
+	"github.com/agilestacks/git-service/cmd/gits/repo"
 	"github.com/agilestacks/git-service/cmd/gits/s3"
 	"github.com/agilestacks/git-service/cmd/gits/ssh"
 	"github.com/agilestacks/git-service/cmd/gits/util"
@@ -13,6 +14,7 @@ import (
 
 func main() {
 	flags.Parse()
+	repo.InitAuth()
 	api.Init()
 	s3.Init()
 	ssh.Listen("0.0.0.0", config.SshPort)
@@ -21,5 +23,6 @@ func main() {
 		log.Printf("Git Service started on HTTP port %d, SSH port %d", config.HttpPort, config.SshPort)
 	}
 	util.Maintenance()
+	repo.TrashPurger()
 	select {}
 }
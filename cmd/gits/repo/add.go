@@ -2,16 +2,21 @@ package repo
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
 )
 
 type AddFile struct {
@@ -20,173 +25,365 @@ type AddFile struct {
 	Mode    os.FileMode
 }
 
-func Add(repoId, branch string, files []AddFile, commitMessage string) error {
-	dir := filepath.Join(config.RepoDir, repoId)
-	if branch == "" {
-		branch = "master"
+type AddMove struct {
+	From string
+	To   string
+}
+
+// AddRequest is a change set committed atomically on top of the branch
+type AddRequest struct {
+	Branch       string
+	Files        []AddFile
+	Delete       []string
+	Move         []AddMove
+	Message      string
+	ExpectedHead string // commit the branch must point to, if set
+	Identity     *CommitIdentity
+}
+
+/* Commits are built directly in the bare repository: blobs are written with `hash-object`, the tree is
+   assembled in a temporary index loaded from branch head, then `commit-tree` and compare-and-swap
+   `update-ref`. If the branch moves concurrently and no expected head is set, the change set is
+   re-applied on top of new head. */
+
+const (
+	addRetries      = 5
+	addRetryBackoff = 50 * time.Millisecond
+)
+
+var errRefMoved = errors.New("ref moved")
+
+// RefMovedError is returned when the branch does not point to expected head,
+// or keeps moving concurrently
+type RefMovedError struct {
+	Branch   string
+	Current  string // empty if branch not found
+	Expected string // empty if no expected head was set
+}
+
+func (e *RefMovedError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("Branch `%s` moved concurrently, update rejected", e.Branch)
+	}
+	current := e.Current
+	if current == "" {
+		current = "not found"
 	}
+	return fmt.Sprintf("Branch `%s` moved to `%s`, expected `%s`", e.Branch, current, e.Expected)
+}
 
-	// temp dir for work tree
-	worktree, err := ioutil.TempDir("", "gits-")
-	if err != nil {
-		return fmt.Errorf("Unable to create temporary directory: %v", err)
+type indexEntry struct {
+	Mode string
+	Id   string
+	Path string
+}
+
+// checkPath returns cleaned slash-separated change set path, or an error if path escapes repository
+func checkPath(p string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(p))
+	if clean == "." || clean == ".." || path.IsAbs(clean) || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Path `%s` is not supported", p)
+	}
+	// Git refuses `.git` anywhere in the tree, and so do clients on case-insensitive filesystems
+	for _, part := range strings.Split(clean, "/") {
+		if strings.EqualFold(part, ".git") {
+			return "", fmt.Errorf("Path `%s` is not supported", p)
+		}
 	}
-	defer deleteDir(worktree)
+	return clean, nil
+}
 
-	gitBin := gitBinPath()
+// gitIndex runs git with temporary index file, output is not trimmed
+func gitIndex(dir, index string, stdin io.Reader, args ...string) (string, error) {
 	var stdoutBuffer bytes.Buffer
-	var stderrBuffer bytes.Buffer
-
-	// show refs to determine if the repo is empty (has the branch) or not
 	cmd := exec.Cmd{
-		Path: gitBin,
-		Dir:  dir,
-		Args: []string{"git", "show-ref"},
+		Path:  gitBinPath(),
+		Dir:   dir,
+		Args:  append([]string{"git"}, args...),
+		Env:   append(os.Environ(), "GIT_INDEX_FILE="+index, "GIT_LITERAL_PATHSPECS=1"),
+		Stdin: stdin,
 	}
-	gitDebug3(&cmd, &stdoutBuffer, &stderrBuffer)
-	err = cmd.Run()
+	gitDebug2(&cmd, &stdoutBuffer)
+	err := cmd.Run()
 	if err != nil {
-		if stdoutBuffer.Len() > 0 || stderrBuffer.Len() > 0 {
-			return fmt.Errorf("Unable to retrieve `%s` Git refs: %v", repoId, err)
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return stdoutBuffer.String(), nil
+}
+
+// indexEntries returns index entries under path
+func indexEntries(dir, index, path string) ([]indexEntry, error) {
+	output, err := gitIndex(dir, index, nil, "ls-files", "-s", "-z", "--", path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]indexEntry, 0)
+	for _, record := range strings.Split(output, "\x00") {
+		// <mode> SP <object> SP <stage> TAB <file>
+		parts := strings.SplitN(record, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[0])
+		if len(fields) != 3 {
+			continue
+		}
+		entries = append(entries, indexEntry{Mode: fields[0], Id: fields[1], Path: parts[1]})
+	}
+	return entries, nil
+}
+
+func updateIndex(dir, index string, entries []indexEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var info bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&info, "%s %s\t%s\x00", entry.Mode, entry.Id, entry.Path)
+	}
+	_, err := gitIndex(dir, index, &info, "update-index", "-z", "--index-info")
+	return err
+}
+
+func removedEntries(entries []indexEntry) []indexEntry {
+	removed := make([]indexEntry, 0, len(entries))
+	for _, entry := range entries {
+		removed = append(removed, indexEntry{Mode: "0", Id: zeroId, Path: entry.Path})
+	}
+	return removed
+}
+
+// writeBlobs stores uploaded files in object database and returns index entries pointing to them
+func writeBlobs(dir string, files []AddFile) ([]indexEntry, error) {
+	entries := make([]indexEntry, 0, len(files))
+	for _, file := range files {
+		path, err := checkPath(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		var stdoutBuffer bytes.Buffer
+		cmd := exec.Cmd{
+			Path:  gitBinPath(),
+			Dir:   dir,
+			Args:  []string{"git", "hash-object", "-w", "--stdin"},
+			Stdin: file.Content,
+		}
+		gitDebug2(&cmd, &stdoutBuffer)
+		err = cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to write `%s` blob: %v", file.Path, err)
 		}
+		// Git records executable bit only
+		mode := "100644"
+		if file.Mode&0111 != 0 {
+			mode = "100755"
+		}
+		entries = append(entries, indexEntry{Mode: mode, Id: strings.TrimSpace(stdoutBuffer.String()), Path: path})
+	}
+	return entries, nil
+}
+
+// checkBranch validates branch name, or full ref name if branch starts with `refs/`
+func checkBranch(dir, branch string) error {
+	if strings.HasPrefix(branch, "-") {
+		return fmt.Errorf("Branch `%s` is not supported", branch)
+	}
+	args := []string{"check-ref-format", "--branch", branch}
+	if strings.HasPrefix(branch, "refs/") {
+		args = []string{"check-ref-format", branch}
+	}
+	output, err := gitOutput(dir, args...)
+	// --branch expands @{-N} syntax, which is not a branch name
+	if err != nil || (args[1] == "--branch" && output != branch) {
+		return fmt.Errorf("Branch `%s` is not supported", branch)
+	}
+	return nil
+}
+
+// Add commits change set and returns new branch head
+func Add(repoId string, req *AddRequest) (string, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	branch := req.Branch
+	if branch == "" {
+		branch = "master"
 	}
 	ref := branch
 	if !strings.HasPrefix(ref, "refs/") {
 		ref = "refs/heads/" + ref
 	}
-	repoEmpty := !strings.Contains(stdoutBuffer.String(), " "+ref)
+	err := checkBranch(dir, branch)
+	if err != nil {
+		return "", err
+	}
+	if req.ExpectedHead != "" && !guessIsCommitHash(req.ExpectedHead) {
+		return "", fmt.Errorf("Expected head `%s` is not supported, must be full commit hash", req.ExpectedHead)
+	}
+	err = req.Identity.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// temp dir for index
+	temp, err := ioutil.TempDir("", "gits-")
+	if err != nil {
+		return "", fmt.Errorf("Unable to create temporary directory: %v", err)
+	}
+	defer deleteDir(temp)
+	index := filepath.Join(temp, "index")
+
+	before := watchRefs(repoId)
+	defer notifyRefChanges(repoId, before, nil, webhooks.TransportApi)
+
+	// blobs do not depend on branch head and are written once
+	files, err := writeBlobs(dir, req.Files)
+	if err != nil {
+		return "", err
+	}
 
-	// clone or init work tree
-	if repoEmpty {
-		cmd = exec.Cmd{
-			Path: gitBin,
-			Dir:  worktree,
-			Args: []string{"git", "init"},
+	for attempt := 0; ; attempt++ {
+		head, err := commitIndex(dir, index, ref, branch, files, req)
+		if err == errRefMoved {
+			if req.ExpectedHead == "" && attempt < addRetries {
+				if config.Debug {
+					log.Printf("Branch `%s` of `%s` moved concurrently, retrying", branch, repoId)
+				}
+				time.Sleep(addRetryBackoff*time.Duration(attempt+1) + time.Duration(rand.Int63n(int64(addRetryBackoff))))
+				continue
+			}
+			return "", &RefMovedError{Branch: branch, Expected: req.ExpectedHead}
 		}
-		gitDebug(&cmd)
-		err = cmd.Run()
 		if err != nil {
-			return fmt.Errorf("Unable to init Git repository `%s` work tree: %v", worktree, err)
+			return "", err
 		}
-		cmd = exec.Cmd{
-			Path: gitBin,
-			Dir:  worktree,
-			Args: []string{"git", "checkout", "-b", branch},
+
+		if config.Verbose {
+			added := make([]string, 0, len(req.Files))
+			for _, file := range req.Files {
+				mode := ""
+				if file.Mode > 0 {
+					mode = fmt.Sprintf(" (%04o)", file.Mode)
+				}
+				added = append(added, file.Path+mode)
+			}
+			log.Printf("Committed `%s` to `%s`: added `%s`, deleted `%s`, moved %d", head, repoId,
+				strings.Join(added, ", "), strings.Join(req.Delete, ", "), len(req.Move))
 		}
-		gitDebug(&cmd)
-		err = cmd.Run()
+		return head, nil
+	}
+}
+
+// commitIndex applies change set on top of branch head and updates the ref, errRefMoved is returned
+// if the ref was updated concurrently
+func commitIndex(dir, index, ref, branch string, files []indexEntry, req *AddRequest) (string, error) {
+	os.Remove(index)
+
+	head, _ := gitOutput(dir, "rev-parse", "-q", "--verify", ref+"^{commit}")
+	if req.ExpectedHead != "" && head != req.ExpectedHead {
+		return "", &RefMovedError{Branch: branch, Current: head, Expected: req.ExpectedHead}
+	}
+	readTree := []string{"read-tree", "--empty"}
+	if head != "" {
+		readTree = []string{"read-tree", head}
+	}
+	_, err := gitIndex(dir, index, nil, readTree...)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read `%s` tree: %v", branch, err)
+	}
+
+	// deletes first, then moves, then uploads
+	for _, p := range req.Delete {
+		path, err := checkPath(p)
 		if err != nil {
-			return fmt.Errorf("Unable to create Git branch `%s` in `%s` work tree: %v", branch, worktree, err)
+			return "", err
 		}
-	} else {
-		cmd := exec.Cmd{
-			Path: gitBin,
-			Dir:  "/",
-			Args: []string{"git", "clone", "--branch", branch, "--single-branch", "--no-tags", dir, worktree},
+		entries, err := indexEntries(dir, index, path)
+		if err != nil {
+			return "", err
 		}
-		gitDebug(&cmd)
-		err = cmd.Run()
+		if len(entries) == 0 {
+			return "", fmt.Errorf("Path `%s` not found", p)
+		}
+		err = updateIndex(dir, index, removedEntries(entries))
 		if err != nil {
-			return fmt.Errorf("Unable to Git clone `%s` into `%s` work tree: %v", repoId, worktree, err)
+			return "", fmt.Errorf("Unable to delete `%s`: %v", p, err)
 		}
 	}
-
-	// add files
-	filesArgs := make([]string, 0, len(files))
-	for _, file := range files {
-		fileDir := filepath.Dir(file.Path)
-		if fileDir != "." {
-			err := os.MkdirAll(filepath.Join(worktree, fileDir), dirMode)
-			if err != nil {
-				return err
-			}
-		}
-		fullPath := filepath.Join(worktree, file.Path)
-		if !strings.HasPrefix(fullPath, worktree) {
-			return fmt.Errorf("Path `%s` is not under work tree `%s` while processing upload `%s`",
-				fullPath, worktree, file.Path)
+	for _, move := range req.Move {
+		from, err := checkPath(move.From)
+		if err != nil {
+			return "", err
 		}
-		mode := file.Mode
-		if mode == 0 {
-			mode = 0644
+		to, err := checkPath(move.To)
+		if err != nil {
+			return "", err
 		}
-		out, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		entries, err := indexEntries(dir, index, from)
 		if err != nil {
-			return err
+			return "", err
+		}
+		if len(entries) == 0 {
+			return "", fmt.Errorf("Path `%s` not found", move.From)
 		}
-		_, err = io.Copy(out, file.Content)
-		out.Close()
+		moved := make([]indexEntry, 0, len(entries))
+		for _, entry := range entries {
+			moved = append(moved, indexEntry{Mode: entry.Mode, Id: entry.Id, Path: to + strings.TrimPrefix(entry.Path, from)})
+		}
+		err = updateIndex(dir, index, append(removedEntries(entries), moved...))
 		if err != nil {
-			return err
+			return "", fmt.Errorf("Unable to move `%s` to `%s`: %v", move.From, move.To, err)
 		}
-		filesArgs = append(filesArgs, file.Path)
 	}
-	cmd = exec.Cmd{
-		Path: gitBin,
-		Dir:  worktree,
-		Args: append([]string{"git", "add"}, filesArgs...),
+	err = updateIndex(dir, index, files)
+	if err != nil {
+		return "", fmt.Errorf("Unable to add files: %v", err)
 	}
-	gitDebug(&cmd)
-	err = cmd.Run()
+
+	tree, err := gitIndex(dir, index, nil, "write-tree")
 	if err != nil {
-		return fmt.Errorf("Unable to add `%s` to `%s` work tree `%s`: %v",
-			strings.Join(filesArgs, ","), repoId, worktree, err)
+		return "", fmt.Errorf("Unable to write tree: %v", err)
+	}
+	tree = strings.TrimSpace(tree)
+	if head != "" {
+		headTree, err := gitOutput(dir, "rev-parse", head+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		// nothing to commit
+		if headTree == tree {
+			return head, nil
+		}
 	}
 
-	// commit
+	commitMessage := req.Message
 	if commitMessage == "" {
 		commitMessage = "Add files"
 	}
-	cmd = exec.Cmd{
-		Path: gitBin,
-		Dir:  worktree,
-		Args: []string{"git", "commit", "-m", commitMessage},
+	commitTree := []string{"commit-tree", tree, "-m", commitMessage}
+	if head != "" {
+		commitTree = append(commitTree, "-p", head)
 	}
-	stdoutBuffer.Reset()
-	gitDebug2(&cmd, &stdoutBuffer)
-	err = cmd.Run()
+	commit, err := gitOutputEnv(dir, req.Identity.env(), commitTree...)
 	if err != nil {
-		if strings.Contains(stdoutBuffer.String(), "nothing to commit, working tree clean") {
-			return nil
-		}
-		return fmt.Errorf("Unable to commit in `%s` work tree `%s`: %v", repoId, worktree, err)
+		return "", fmt.Errorf("Unable to commit: %v", err)
 	}
 
-	// push
-	if repoEmpty {
-		cmd = exec.Cmd{
-			Path: gitBin,
-			Dir:  worktree,
-			Args: []string{"git", "remote", "add", "origin", dir},
-		}
-		gitDebug(&cmd)
-		err = cmd.Run()
-		if err != nil {
-			return fmt.Errorf("Unable to add origin remote `%s` to `%s` work tree: %v", dir, worktree, err)
-		}
-	}
-	cmd = exec.Cmd{
-		Path: gitBin,
-		Dir:  worktree,
-		Args: []string{"git", "push", "origin", branch},
+	old := head
+	if old == "" {
+		old = zeroId
 	}
-	gitDebug(&cmd)
-	err = cmd.Run()
+	_, err = gitOutput(dir, "update-ref", "-m", commitMessage, ref, commit, old)
 	if err != nil {
-		return fmt.Errorf("Unable to push `%s` into `%s` from `%s` work tree: %v", branch, repoId, worktree, err)
-	}
-
-	if config.Verbose {
-		added := make([]string, 0, len(files))
-		for _, file := range files {
-			mode := ""
-			if file.Mode > 0 {
-				mode = fmt.Sprintf(" (%04o)", file.Mode)
-			}
-			added = append(added, file.Path+mode)
+		current, _ := gitOutput(dir, "rev-parse", "-q", "--verify", ref)
+		if current != head {
+			return "", errRefMoved
 		}
-		log.Printf("Added `%s` to `%s`", strings.Join(added, ", "), repoId)
+		return "", fmt.Errorf("Unable to update `%s`: %v", ref, err)
 	}
-
-	return nil
+	return commit, nil
 }
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func addFile(path, content string) AddFile {
+	return AddFile{Path: path, Content: strings.NewReader(content)}
+}
+
+func TestCheckPath(t *testing.T) {
+	for p, expected := range map[string]string{
+		"README":        "README",
+		"./a//b/../c":   "a/c",
+		"dir/.gitkeep":  "dir/.gitkeep",
+		"a/b/.github/x": "a/b/.github/x",
+	} {
+		clean, err := checkPath(p)
+		if err != nil || clean != expected {
+			t.Errorf("Expected `%s` cleaned to `%s`, got `%s`: %v", p, expected, clean, err)
+		}
+	}
+	for _, p := range []string{"", ".", "..", "../x", "a/../../x", "/etc/passwd", ".git", ".git/config", "a/.git/config", ".GIT/config"} {
+		if _, err := checkPath(p); err == nil {
+			t.Errorf("Expected `%s` to be rejected", p)
+		}
+	}
+}
+
+func TestAddOrdering(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "a.txt", "a")
+	commitFile(t, work, "dir/b.txt", "b")
+	dir := bareRepo(t, "add/order-1", work)
+
+	// deletes first, then moves, then uploads: a.txt is re-created, dir/ is moved and extended
+	head, err := Add("add/order-1", &AddRequest{
+		Delete: []string{"a.txt"},
+		Move:   []AddMove{{From: "dir", To: "new"}},
+		Files:  []AddFile{addFile("a.txt", "new a"), addFile("new/c.txt", "c")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := git(t, dir, "ls-tree", "-r", "--name-only", head); files != "a.txt\nnew/b.txt\nnew/c.txt" {
+		t.Errorf("Unexpected tree:\n%s", files)
+	}
+	if content := git(t, dir, "show", head+":a.txt"); content != "new a" {
+		t.Errorf("Expected uploaded a.txt, got %q", content)
+	}
+	if message := git(t, dir, "log", "-1", "--format=%s", head); message != "Add files" {
+		t.Errorf("Unexpected message %q", message)
+	}
+
+	// unchanged tree is not committed
+	same, err := Add("add/order-1", &AddRequest{Files: []AddFile{addFile("a.txt", "new a")}})
+	if err != nil || same != head {
+		t.Errorf("Expected no commit for unchanged tree, got %s: %v", same, err)
+	}
+
+	for _, req := range []*AddRequest{
+		{Delete: []string{"missing"}},
+		{Move: []AddMove{{From: "missing", To: "x"}}},
+	} {
+		_, err = Add("add/order-1", req)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected missing path not found, got %v", err)
+		}
+	}
+	for _, req := range []*AddRequest{
+		{Delete: []string{"../a.txt"}},
+		{Move: []AddMove{{From: "new", To: ".git/hooks"}}},
+		{Files: []AddFile{addFile("/etc/passwd", "x")}},
+	} {
+		_, err = Add("add/order-1", req)
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("Expected path escape not supported, got %v", err)
+		}
+	}
+	if current := git(t, dir, "rev-parse", "master"); current != head {
+		t.Errorf("Expected rejected requests to leave master at %s, got %s", head, current)
+	}
+}
+
+func TestAddBranch(t *testing.T) {
+	work := workRepo(t)
+	base := commitFile(t, work, "README", "a")
+	dir := bareRepo(t, "add/branch-1", work)
+
+	head, err := Add("add/branch-1", &AddRequest{Branch: "feature/x", Files: []AddFile{addFile("b", "b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// new branch starts from empty tree
+	if files := git(t, dir, "ls-tree", "--name-only", head); files != "b" {
+		t.Errorf("Unexpected new branch tree %q", files)
+	}
+	_, err = Add("add/branch-1", &AddRequest{Branch: "refs/heads/other", Files: []AddFile{addFile("c", "c")}})
+	if err != nil {
+		t.Errorf("Expected full ref name to be accepted: %v", err)
+	}
+	for _, branch := range []string{"-x", "a..b", "a b", "HEAD", "@{-1}", "x.lock", "refs/heads/a..b", "a~1"} {
+		_, err = Add("add/branch-1", &AddRequest{Branch: branch, Files: []AddFile{addFile("b", "b")}})
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("Expected branch `%s` not supported, got %v", branch, err)
+		}
+	}
+	if current := git(t, dir, "rev-parse", "master"); current != base {
+		t.Errorf("Expected master to stay at %s, got %s", base, current)
+	}
+}
+
+func TestAddExpectedHead(t *testing.T) {
+	work := workRepo(t)
+	base := commitFile(t, work, "README", "a")
+	bareRepo(t, "add/expected-1", work)
+
+	head, err := Add("add/expected-1", &AddRequest{ExpectedHead: base, Files: []AddFile{addFile("b", "b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Add("add/expected-1", &AddRequest{ExpectedHead: base, Files: []AddFile{addFile("c", "c")}})
+	var moved *RefMovedError
+	if !errors.As(err, &moved) || moved.Current != head || moved.Expected != base {
+		t.Errorf("Expected branch moved to %s, got %v", head, err)
+	}
+	_, err = Add("add/expected-1", &AddRequest{Branch: "missing", ExpectedHead: base, Files: []AddFile{addFile("c", "c")}})
+	if !errors.As(err, &moved) || moved.Current != "" || !strings.Contains(err.Error(), "moved to `not found`") {
+		t.Errorf("Expected missing branch reported, got %v", err)
+	}
+	_, err = Add("add/expected-1", &AddRequest{ExpectedHead: "master", Files: []AddFile{addFile("c", "c")}})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected symbolic expected head not supported, got %v", err)
+	}
+}
+
+// concurrentUpdateHook moves the branch behind `update-ref` back once, as a concurrent writer would
+const concurrentUpdateHook = `#!/bin/sh
+test "$1" = prepared || exit 0
+test -f concurrent.done && exit 0
+touch concurrent.done
+echo "$CONCURRENT_COMMIT" > refs/heads/master
+exit 1
+`
+
+func TestAddRetriesMovedRef(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "a")
+	dir := bareRepo(t, "add/retry-1", work)
+	concurrent := commitFile(t, work, "other", "concurrent")
+	git(t, dir, "fetch", "-q", work, "master")
+
+	hook := filepath.Join(dir, "hooks", "reference-transaction")
+	err := ioutil.WriteFile(hook, []byte(concurrentUpdateHook), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONCURRENT_COMMIT", concurrent)
+	defer os.Unsetenv("CONCURRENT_COMMIT")
+
+	head, err := Add("add/retry-1", &AddRequest{Files: []AddFile{addFile("b", "b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "concurrent.done")); err != nil {
+		t.Skip("Git does not support reference-transaction hook")
+	}
+	if parent := git(t, dir, "rev-parse", head+"^"); parent != concurrent {
+		t.Errorf("Expected change set re-applied on top of %s, got parent %s", concurrent, parent)
+	}
+	if files := git(t, dir, "ls-tree", "--name-only", head); files != "README\nb\nother" {
+		t.Errorf("Unexpected tree %q", files)
+	}
+
+	// with expected head set the change set is not re-applied
+	os.Remove(filepath.Join(dir, "concurrent.done"))
+	_, err = Add("add/retry-1", &AddRequest{ExpectedHead: head, Files: []AddFile{addFile("c", "c")}})
+	var moved *RefMovedError
+	if !errors.As(err, &moved) {
+		t.Errorf("Expected branch moved concurrently, got %v", err)
+	}
+}
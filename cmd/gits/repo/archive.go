@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+var archiveFormats = map[string]string{
+	"tar.gz": "tar",
+	"tgz":    "tar",
+	"zip":    "zip",
+}
+
+func ArchiveFormat(format string) bool {
+	_, exist := archiveFormats[format]
+	return exist
+}
+
+// ArchiveCheck verifies archive could be created so that errors are reported before the response is started
+func ArchiveCheck(repoId, ref, path, format string) error {
+	dir := filepath.Join(config.RepoDir, repoId)
+	if !ArchiveFormat(format) {
+		return fmt.Errorf("Archive format `%s` is not supported", format)
+	}
+	path = archivePath(path)
+	_, err := resolveTree(dir, ref, "")
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		_, err = gitOutput(dir, "cat-file", "-e", ref+":"+path)
+		if err != nil {
+			return fmt.Errorf("Path `%s` not found", path)
+		}
+	}
+	return nil
+}
+
+func archivePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "." {
+		path = ""
+	}
+	return path
+}
+
+// Archive streams `git archive` of `ref` in `format`, optionally restricted to `path`;
+// files are placed under `prefix` directory. The output is incomplete on error.
+func Archive(repoId, ref, path, format, prefix string, out io.Writer) error {
+	dir := filepath.Join(config.RepoDir, repoId)
+	gitFormat, exist := archiveFormats[format]
+	if !exist {
+		return fmt.Errorf("Archive format `%s` is not supported", format)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("Ref `%s` is not supported", ref)
+	}
+	path = archivePath(path)
+
+	args := []string{"git", "archive", "--format=" + gitFormat}
+	if prefix != "" {
+		args = append(args, "--prefix="+strings.TrimSuffix(prefix, "/")+"/")
+	}
+	args = append(args, ref)
+	if path != "" {
+		args = append(args, "--", path)
+	}
+
+	var gz *gzip.Writer
+	if format != "zip" {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: args,
+	}
+	gitDebug4(&cmd, out)
+	err := cmd.Run()
+	if gz != nil {
+		closeErr := gz.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to archive `%s` ref `%s`: %v", repoId, ref, err)
+	}
+	return nil
+}
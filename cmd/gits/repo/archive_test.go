@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveCheck(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "docs/guide", "guide\n")
+	bareRepo(t, "archive/check-1", work)
+
+	if err := ArchiveCheck("archive/check-1", "master", "/docs/", "tgz"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		ref, path, format, err string
+	}{
+		{"master", "", "rar", "not supported"},
+		{"--output=x", "", "zip", "not supported"},
+		{"missing", "", "zip", "not found"},
+		{"master", "missing", "zip", "not found"},
+	} {
+		err := ArchiveCheck("archive/check-1", test.ref, test.path, test.format)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected `%s` error for %+v, got %v", test.err, test, err)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "readme\n")
+	commitFile(t, work, "docs/guide", "guide\n")
+	bareRepo(t, "archive/archive-1", work)
+
+	var out bytes.Buffer
+	err := Archive("archive/archive-1", "master", "docs", "tar.gz", "archive-1-master", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(archive)
+		if header.Typeflag == tar.TypeReg {
+			files[header.Name] = string(content)
+		}
+	}
+	if len(files) != 1 || files["archive-1-master/docs/guide"] != "guide\n" {
+		t.Errorf("Unexpected tar.gz files %v", files)
+	}
+
+	out.Reset()
+	err = Archive("archive/archive-1", "master", "", "zip", "", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipped, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, file := range zipped.File {
+		if !strings.HasSuffix(file.Name, "/") {
+			names = append(names, file.Name)
+		}
+	}
+	if strings.Join(names, " ") != "README docs/guide" {
+		t.Errorf("Unexpected zip files %v", names)
+	}
+
+	for _, ref := range []string{"--output=" + filepath.Join(work, "output"), "missing"} {
+		err = Archive("archive/archive-1", ref, "", "tgz", "", ioutil.Discard)
+		if err == nil {
+			t.Errorf("Expected error archiving ref `%s`", ref)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(work, "output")); err == nil {
+		t.Error("Archive wrote output file")
+	}
+}
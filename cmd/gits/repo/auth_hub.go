@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/extapi"
+)
+
+// hubAuth checks access with Automation Hub templates and teams, and Auth Service users
+type hubAuth struct{}
+
+func (hubAuth) UsersBySshKey(keyBase64, keyFingerprintSHA256 string) ([]string, error) {
+	return extapi.UsersBySshKey(keyBase64, keyFingerprintSHA256)
+}
+
+func (hubAuth) Access(repo string, verb string, users []string) (bool, error) {
+	orgId, err := orgId(repo)
+	if err != nil {
+		return false, err
+	}
+	templateId, err := TemplateId(repo)
+	if err != nil {
+		return false, err
+	}
+
+	org, err := extapi.OrgById(orgId)
+	if err != nil {
+		return false, fmt.Errorf("Unable to fetch organization `%s` info: %v", orgId, err)
+	}
+	if !org.ShowSource {
+		return false, fmt.Errorf("Organization `%s` has no source code access", orgId)
+	}
+
+	template, err := extapi.TemplateById(templateId)
+	if err != nil {
+		return false, fmt.Errorf("Unable to fetch template `%s` info: %v", templateId, err)
+	}
+
+	writeRequested := verb == "git-receive-pack"
+
+	if contains(users, template.OwnerUserId) {
+		return true, nil
+	}
+
+	teams := make([]extapi.TeamAccess, 0, len(template.Teams))
+	for _, team := range template.Teams {
+		if !writeRequested || team.CanWrite {
+			teams = append(teams, team)
+		}
+	}
+	return teamsGrant(teams, users)
+}
+
+func (hubAuth) AccessWithLogin(org, repo, verb, username, password string) (bool, string, error) {
+	orgId, err := orgId(repo)
+	if err != nil {
+		return false, "", err
+	}
+	templateId, err := TemplateId(repo)
+	if err != nil {
+		return false, "", err
+	}
+
+	user, err := extapi.Login(username, password)
+	if err != nil {
+		return false, "", fmt.Errorf("Unable to signin user `%s`: %v", username, err)
+	}
+
+	if strings.ToLower(org) != strings.ToLower(user.Organization) {
+		return false, "", fmt.Errorf("User org `%s` does not match repo org `%s`", user.Organization, org)
+	}
+
+	hubOrg, err := extapi.OrgById(orgId)
+	if err != nil {
+		return false, "", fmt.Errorf("Unable to fetch organization `%s` info: %v", orgId, err)
+	}
+	if !hubOrg.ShowSource {
+		return false, "", fmt.Errorf("Organization `%s` has no source code access", orgId)
+	}
+
+	template, err := extapi.TemplateById(templateId)
+	if err != nil {
+		return false, "", fmt.Errorf("Unable to fetch template `%s` info: %v", templateId, err)
+	}
+
+	if user.Uid == template.OwnerUserId {
+		return true, user.Uid, nil
+	}
+
+	writeRequested := verb == "git-receive-pack"
+
+	for _, userTeam := range user.Groups {
+		for _, templateTeam := range template.Teams {
+			if userTeam == templateTeam.TeamName && (!writeRequested || templateTeam.CanWrite) {
+				return true, user.Uid, nil
+			}
+		}
+	}
+
+	return false, user.Uid, nil
+}
+
+func (hubAuth) RepoOwner(repo string) (string, error) {
+	templateId, err := TemplateId(repo)
+	if err != nil {
+		return "", err
+	}
+	template, err := extapi.TemplateById(templateId)
+	if err != nil {
+		return "", fmt.Errorf("Unable to fetch template `%s` info: %v", templateId, err)
+	}
+	return template.OwnerUserId, nil
+}
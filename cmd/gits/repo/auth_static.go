@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+/* Static auth is read from YAML file set by -auth_static_file:
+
+users:
+  alice:
+    password: $2a$10$...   # bcrypt hash
+    keys:
+      - ssh-ed25519 AAAAC3Nza... alice@laptop
+organizations:
+  acme:                    # grants on all organization repositories
+    owner: alice
+    write: [bob]
+    read: [carol]
+repositories:
+  acme/secret-*:           # path.Match pattern of repo id, takes precedence over organization;
+                           # the longest matching pattern wins, then the one with fewer wildcards
+    owner: bob
+    read: [alice]
+
+Owner is granted write access. */
+
+type StaticUser struct {
+	Password string   `yaml:"password"`
+	Keys     []string `yaml:"keys"`
+}
+
+type StaticGrant struct {
+	Owner string   `yaml:"owner"`
+	Write []string `yaml:"write"`
+	Read  []string `yaml:"read"`
+}
+
+type staticAuth struct {
+	Users         map[string]StaticUser  `yaml:"users"`
+	Organizations map[string]StaticGrant `yaml:"organizations"`
+	Repositories  map[string]StaticGrant `yaml:"repositories"`
+}
+
+func loadStaticAuth(filename string) (*staticAuth, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("Static auth file is not set, use -auth_static_file")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var auth staticAuth
+	err = yaml.UnmarshalStrict(data, &auth)
+	if err != nil {
+		return nil, err
+	}
+	for pattern := range auth.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad repository pattern `%s`: %v", pattern, err)
+		}
+	}
+	return &auth, nil
+}
+
+func (auth *staticAuth) UsersBySshKey(keyBase64, keyFingerprintSHA256 string) ([]string, error) {
+	users := make([]string, 0)
+	for userId, user := range auth.Users {
+		for _, key := range user.Keys {
+			// type, base64 key, optional comment
+			fields := strings.Fields(key)
+			if len(fields) >= 2 && fields[1] == keyBase64 {
+				users = append(users, userId)
+				break
+			}
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// moreSpecific orders repository patterns: longer first, then fewer wildcards, then lexically
+func moreSpecific(pattern, than string) bool {
+	if len(pattern) != len(than) {
+		return len(pattern) > len(than)
+	}
+	wildcards := strings.Count(pattern, "*") + strings.Count(pattern, "?") + strings.Count(pattern, "[")
+	thanWildcards := strings.Count(than, "*") + strings.Count(than, "?") + strings.Count(than, "[")
+	if wildcards != thanWildcards {
+		return wildcards < thanWildcards
+	}
+	return pattern < than
+}
+
+// grant returns the most specific grant on the repo: best matching repository pattern, then organization
+func (auth *staticAuth) grant(repo string) (*StaticGrant, error) {
+	orgId, err := orgId(repo)
+	if err != nil {
+		return nil, err
+	}
+	matched := ""
+	for pattern := range auth.Repositories {
+		if ok, _ := path.Match(pattern, repo); ok && (matched == "" || moreSpecific(pattern, matched)) {
+			matched = pattern
+		}
+	}
+	if matched != "" {
+		grant := auth.Repositories[matched]
+		return &grant, nil
+	}
+	if grant, exist := auth.Organizations[orgId]; exist {
+		return &grant, nil
+	}
+	return nil, fmt.Errorf("No grants on `%s` found", repo)
+}
+
+func (grant *StaticGrant) allows(userId string, write bool) bool {
+	if userId == "" {
+		return false
+	}
+	if userId == grant.Owner || contains(grant.Write, userId) {
+		return true
+	}
+	return !write && contains(grant.Read, userId)
+}
+
+func (auth *staticAuth) Access(repo string, verb string, users []string) (bool, error) {
+	grant, err := auth.grant(repo)
+	if err != nil {
+		return false, err
+	}
+	for _, userId := range users {
+		if grant.allows(userId, verb == "git-receive-pack") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (auth *staticAuth) AccessWithLogin(org, repo, verb, username, password string) (bool, string, error) {
+	user, exist := auth.Users[username]
+	if !exist || user.Password == "" {
+		return false, "", fmt.Errorf("No `%s` user found", username)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return false, "", fmt.Errorf("Unable to signin user `%s`: %v", username, err)
+	}
+	hasAccess, err := auth.Access(repo, verb, []string{username})
+	return hasAccess, username, err
+}
+
+func (auth *staticAuth) RepoOwner(repo string) (string, error) {
+	grant, err := auth.grant(repo)
+	if err != nil {
+		return "", err
+	}
+	return grant.Owner, nil
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaticAuth(t *testing.T) {
+	auth, err := loadStaticAuth("testdata/auth_static.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := auth.UsersBySshKey("AAAAshared", "")
+	if err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Errorf("Expected shared key users [alice bob], got %v: %v", users, err)
+	}
+	users, _ = auth.UsersBySshKey("AAAAnobody", "")
+	if len(users) != 0 {
+		t.Errorf("Expected no users for unknown key, got %v", users)
+	}
+
+	ok, userId, err := auth.AccessWithLogin("acme", "acme/app-1", "git-receive-pack", "alice", "alice-secret")
+	if err != nil || !ok || userId != "alice" {
+		t.Errorf("Expected alice to login and push, got %v %q: %v", ok, userId, err)
+	}
+	if _, _, err = auth.AccessWithLogin("acme", "acme/app-1", "git-upload-pack", "alice", "wrong"); err == nil {
+		t.Error("Expected wrong password to fail")
+	}
+	if _, _, err = auth.AccessWithLogin("acme", "acme/app-1", "git-upload-pack", "bob", ""); err == nil {
+		t.Error("Expected user without password to fail")
+	}
+
+	owners := map[string]string{
+		"acme/app-1":     "alice", // organization
+		"acme/secret-x":  "bob",   // secret-* and secret-? tie on length and wildcards, lexical order
+		"acme/secret-1":  "dave",  // no wildcards wins over secret-?
+		"acme/secret-xx": "bob",
+		"acme/xz":        "x2", // *z before x?
+		"acme/xy":        "x1",
+	}
+	for repo, expected := range owners {
+		owner, err := auth.RepoOwner(repo)
+		if err != nil || owner != expected {
+			t.Errorf("Expected `%s` owner %q, got %q: %v", repo, expected, owner, err)
+		}
+	}
+	if _, err = auth.RepoOwner("other/app-1"); err == nil {
+		t.Error("Expected no grants on other organization")
+	}
+
+	access := []struct {
+		repo  string
+		verb  string
+		users []string
+		ok    bool
+	}{
+		{"acme/app-1", "git-receive-pack", []string{"alice"}, true},
+		{"acme/app-1", "git-receive-pack", []string{"bob"}, true},
+		{"acme/app-1", "git-receive-pack", []string{"carol"}, false},
+		{"acme/app-1", "git-upload-pack", []string{"carol"}, true},
+		{"acme/app-1", "git-upload-pack", []string{"mallory"}, false},
+		{"acme/secret-xx", "git-upload-pack", []string{"alice"}, true},
+		{"acme/secret-xx", "git-receive-pack", []string{"alice"}, false},
+		{"acme/secret-xx", "git-receive-pack", []string{"alice", "bob"}, true},
+		{"acme/secret-xx", "git-upload-pack", []string{"carol"}, false},
+		{"acme/secret-xx", "git-upload-pack", []string{""}, false},
+	}
+	for _, test := range access {
+		ok, err := auth.Access(test.repo, test.verb, test.users)
+		if err != nil || ok != test.ok {
+			t.Errorf("Expected %v %s on `%s` to be %v, got %v: %v", test.users, test.verb, test.repo, test.ok, ok, err)
+		}
+	}
+}
@@ -1,67 +1,93 @@
 package repo
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
 )
 
-func Blob(repoId, ref, path string) (io.ReadCloser, error) {
+// BlobEntry looks up file at `ref` and returns it's tree entry, blob id is the file content id
+func BlobEntry(repoId, ref, path string) (*TreeEntry, error) {
 	dir := filepath.Join(config.RepoDir, repoId)
 	if ref == "" {
 		ref = "master"
 	}
-
-	// temp dir for work tree
-	worktree, err := ioutil.TempDir("", "gits-")
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create temporary directory: %v", err)
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		return nil, errors.New("is a directory")
 	}
-	defer deleteDir(worktree)
 
-	// checkout
-	cmd := exec.Cmd{
-		Path: gitBinPath(),
-		Dir:  dir,
-		Args: []string{"git", "--work-tree", worktree, "checkout", ref, "--", path},
+	tree, err := resolveTree(dir, ref, "")
+	if err != nil {
+		return nil, err
 	}
-	var stdoutBuffer bytes.Buffer
-	gitDebug2(&cmd, &stdoutBuffer)
-	err = cmd.Run()
+	output, err := gitOutput(dir, "ls-tree", "-l", "-z", tree, "--", path)
 	if err != nil {
-		if strings.Contains(stdoutBuffer.String(), "dit not match any") {
-			return nil, errors.New("not found")
-		}
-		return nil, fmt.Errorf("Unable to checkout `%s` path `%s` at ref `%s`: %v", repoId, path, ref, err)
+		return nil, fmt.Errorf("Unable to lookup `%s` path `%s` at ref `%s`: %v", repoId, path, ref, err)
 	}
-	fullPath := filepath.Join(worktree, path)
-	if !strings.HasPrefix(fullPath, worktree) {
-		return nil, fmt.Errorf("Path `%s` is not under work tree `%s` while processing download `%s`",
-			path, worktree, path)
+	// <mode> SP <type> SP <object> SP+ <size> TAB <path> NUL
+	line := strings.TrimSuffix(output, "\x00")
+	tab := strings.Index(line, "\t")
+	if tab < 0 || line[tab+1:] != path {
+		return nil, errors.New("not found")
 	}
-
-	info, err := os.Lstat(fullPath)
-	if err != nil {
-		return nil, err
+	fields := strings.Fields(line[:tab])
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("Unexpected `git ls-tree` output %q", output)
 	}
-	if info.IsDir() {
+	entry := &TreeEntry{Name: filepath.Base(path), Path: path, Type: fields[1], Mode: fields[0], Id: fields[2]}
+	if entry.Type == "tree" {
 		return nil, errors.New("is a directory")
 	}
-	if info.Mode()&os.ModeType != 0 {
+	if entry.Type != "blob" || entry.Mode == modeSymlink {
 		return nil, errors.New("not a regular file")
 	}
-	file, err := os.Open(fullPath)
+	size, err := strconv.ParseInt(fields[3], 10, 64)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Unexpected `git ls-tree` output %q", output)
 	}
+	entry.Size = &size
+	return entry, nil
+}
+
+type gitReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
 
-	return file, nil
+func (r *gitReader) Close() error {
+	err := r.ReadCloser.Close()
+	waitErr := r.cmd.Wait()
+	if waitErr != nil {
+		return fmt.Errorf("git %s: %v", strings.Join(r.cmd.Args[1:], " "), waitErr)
+	}
+	return err
+}
+
+// BlobContent streams blob content, the reader must be closed to reap Git process
+func BlobContent(repoId, id string) (io.ReadCloser, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: []string{"git", "cat-file", "blob", id},
+	}
+	if config.Trace {
+		printGitArgs(&cmd)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read `%s` blob `%s`: %v", repoId, id, err)
+	}
+	return &gitReader{ReadCloser: stdout, cmd: &cmd}, nil
 }
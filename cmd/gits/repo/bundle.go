@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+// BundleCheck verifies bundle could be created so that errors are reported before the response is started
+func BundleCheck(repoId string, refs []string) error {
+	dir := filepath.Join(config.RepoDir, repoId)
+	if len(refs) == 0 {
+		output, err := gitOutput(dir, "for-each-ref", "--count=1", "--format=%(refname)")
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			return fmt.Errorf("Repository `%s` is empty, refs not found", repoId)
+		}
+		return nil
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "-") {
+			return fmt.Errorf("Ref `%s` is not supported", ref)
+		}
+		_, err := gitOutput(dir, "rev-parse", "-q", "--verify", ref)
+		if err != nil {
+			return fmt.Errorf("Ref `%s` not found", ref)
+		}
+	}
+	return nil
+}
+
+// Bundle streams `git bundle` of `refs`, or of all refs if none are specified
+func Bundle(repoId string, refs []string, out io.Writer) error {
+	dir := filepath.Join(config.RepoDir, repoId)
+	args := []string{"git", "bundle", "create", "-"}
+	if len(refs) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(args, refs...)
+	}
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: args,
+	}
+	gitDebug4(&cmd, out)
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Unable to bundle `%s`: %v", repoId, err)
+	}
+	return nil
+}
+
+// initWithBundle fetches all refs from the bundle into new bare repository
+func initWithBundle(dir string, bundle io.Reader) error {
+	file, err := ioutil.TempFile("", "gits-bundle-")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, bundle)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to read bundle: %v", err)
+	}
+
+	err = initBare(dir)
+	if err != nil {
+		return err
+	}
+	_, err = gitOutput(dir, "bundle", "verify", "-q", file.Name())
+	if err != nil {
+		return fmt.Errorf("Bundle is not supported, verification failed: %v", err)
+	}
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: []string{"git", "fetch", "-q", "-n", file.Name(), "+refs/*:refs/*"},
+	}
+	gitDebug(&cmd)
+	err = cmd.Run()
+	if err != nil {
+		log.Printf("`git fetch` failed: %v", err)
+		return err
+	}
+	heads, err := gitOutput(dir, "bundle", "list-heads", file.Name())
+	if err != nil {
+		return err
+	}
+	return setBundleHead(dir, heads)
+}
+
+// setBundleHead points HEAD to the branch the bundle HEAD is at; bundle records HEAD as commit id only,
+// so if a few branches are at the same commit, the current HEAD (default branch) is preferred
+func setBundleHead(dir, heads string) error {
+	head := ""
+	branches := make(map[string]string)
+	order := make([]string, 0)
+	for _, line := range strings.Split(heads, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[1] == "HEAD" {
+			head = parts[0]
+		} else if strings.HasPrefix(parts[1], "refs/heads/") {
+			branches[parts[1]] = parts[0]
+			order = append(order, parts[1])
+		}
+	}
+	current, err := gitOutput(dir, "symbolic-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+	if _, exist := branches[current]; exist && (head == "" || branches[current] == head) {
+		return nil
+	}
+	for _, branch := range order {
+		if head == "" || branches[branch] == head {
+			_, err = gitOutput(dir, "symbolic-ref", "HEAD", branch)
+			return err
+		}
+	}
+	return nil
+}
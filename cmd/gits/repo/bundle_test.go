@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func TestBundleCheck(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "a\n")
+	bareRepo(t, "bundle/check-1", work)
+	err := Create("bundle/empty-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BundleCheck("bundle/check-1", nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		repoId string
+		refs   []string
+		err    string
+	}{
+		{"bundle/check-1", []string{"master", "--all"}, "not supported"},
+		{"bundle/check-1", []string{"missing"}, "not found"},
+		{"bundle/empty-1", nil, "not found"},
+	} {
+		err := BundleCheck(test.repoId, test.refs)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected `%s` error for %+v, got %v", test.err, test, err)
+		}
+	}
+}
+
+func TestBundleImport(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "a\n")
+	git(t, work, "branch", "aaa")
+	git(t, work, "checkout", "-q", "-b", "develop")
+	commitFile(t, work, "README", "b\n")
+	git(t, work, "tag", "v1")
+	source := bareRepo(t, "bundle/export-1", work)
+	refs := git(t, source, "for-each-ref", "--format=%(objectname) %(refname)")
+
+	for i, test := range []struct {
+		head     string   // HEAD of exported repository
+		bundled  []string // refs to bundle, all if empty
+		imported string   // HEAD of imported repository
+		refs     string   // refs of imported repository, not checked if empty
+	}{
+		{"refs/heads/develop", nil, "refs/heads/develop", refs},
+		// few branches at bundle HEAD, default branch is preferred
+		{"refs/heads/master", nil, "refs/heads/master", refs},
+		// no HEAD in bundle
+		{"refs/heads/master", []string{"develop", "v1"}, "refs/heads/develop", ""},
+	} {
+		git(t, source, "symbolic-ref", "HEAD", test.head)
+		var bundle bytes.Buffer
+		err := Bundle("bundle/export-1", test.bundled, &bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repoId := fmt.Sprintf("bundle/import-%d", i+1)
+		err = Create(repoId, &CreateRequest{Bundle: &bundle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(config.RepoDir, repoId)
+		if head := git(t, dir, "symbolic-ref", "HEAD"); head != test.imported {
+			t.Errorf("Expected HEAD %s with %s exported, got %s", test.imported, test.head, head)
+		}
+		if test.refs != "" {
+			if imported := git(t, dir, "for-each-ref", "--format=%(objectname) %(refname)"); imported != test.refs {
+				t.Errorf("Expected refs:\n%s\ngot:\n%s", test.refs, imported)
+			}
+		}
+	}
+
+	err := Create("bundle/import-bad", &CreateRequest{Bundle: strings.NewReader("not a bundle")})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected bundle verification error, got %v", err)
+	}
+	if Exist("bundle/import-bad") {
+		t.Error("Repository with failed import is not removed")
+	}
+}
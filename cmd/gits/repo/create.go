@@ -1,8 +1,8 @@
 package repo
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -14,16 +14,44 @@ import (
 )
 
 type CreateRequest struct {
-	Remote  string
-	Ref     string
-	Squash  bool
-	Message string
-	Archive string
+	Remote         string
+	Source         string // id of repository on this server to copy
+	Ref            string
+	Squash         bool
+	Message        string
+	Archive        string
+	Bundle         io.Reader `json:"-"` // git bundle to fetch all refs from
+	CommitIdentity           // of squashed commit
 }
 
 func Create(repoId string, req *CreateRequest) error {
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if req != nil {
+		sources := 0
+		for _, source := range []string{req.Remote, req.Source, req.Archive} {
+			if source != "" {
+				sources++
+			}
+		}
+		if req.Bundle != nil {
+			sources++
+		}
+		if sources > 1 {
+			return fmt.Errorf("Setting more than one of `remote`, `source`, `archive`, bundle is not supported")
+		}
+		err = req.CommitIdentity.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
 	dir := filepath.Join(config.RepoDir, repoId)
-	_, err := os.Stat(dir)
+	_, err = os.Stat(dir)
 	if err == nil {
 		return fmt.Errorf("Directory already exists: %s", dir)
 	}
@@ -33,9 +61,13 @@ func Create(repoId string, req *CreateRequest) error {
 	}
 	if req != nil && req.Archive != "" {
 		err = initWithArchive(dir, req.Archive)
+	} else if req != nil && req.Bundle != nil {
+		err = initWithBundle(dir, req.Bundle)
+	} else if req != nil && req.Source != "" {
+		err = initWithSource(dir, req.Source, req.Ref, req.Squash, req.Message, &req.CommitIdentity)
 	} else if req != nil && req.Remote != "" {
 		if req.Squash {
-			err = errors.New("Squash not implemented")
+			err = initWithRemoteSquash(dir, req.Remote, req.Ref, req.Message, &req.CommitIdentity)
 		} else {
 			err = initWithRemote(dir, req.Remote, req.Ref)
 		}
@@ -95,3 +127,104 @@ func initWithRemote(dir, remote, ref string) error {
 	}
 	return nil
 }
+
+// initWithSource copies local repository: whole repository is cloned with objects hardlinked,
+// single ref is fetched as `master`
+func initWithSource(dir, source, ref string, squash bool, message string, identity *CommitIdentity) error {
+	err := checkRepoId(source)
+	if err != nil {
+		return err
+	}
+	// source must not change while it's copied, shared lock is taken after the destination lock
+	// and might time out if source is being moved into the destination
+	unlock, err := lockRepoShared(source)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !Exist(source) {
+		return fmt.Errorf("Source repository `%s` not found", source)
+	}
+	sourceDir := filepath.Join(config.RepoDir, source)
+
+	if squash {
+		if ref == "" {
+			ref = "master"
+		}
+		if message == "" {
+			message = fmt.Sprintf("Import %s@%s", source, ref)
+		}
+		// shallow fetch requires file:// transport
+		return initWithRemoteSquash(dir, "file://"+sourceDir, ref, message, identity)
+	}
+	if ref != "" {
+		return initWithRemote(dir, sourceDir, ref)
+	}
+
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  "/",
+		Args: []string{"git", "clone", "-q", "--bare", "--local", sourceDir, dir},
+	}
+	gitDebug(&cmd)
+	err = cmd.Run()
+	if err != nil {
+		log.Printf("`git clone` failed: %v", err)
+		return err
+	}
+	_, err = gitOutput(dir, "remote", "remove", "origin")
+	if err != nil {
+		log.Printf("Unable to remove `origin` remote: %v", err)
+	}
+	return nil
+}
+
+// initWithRemoteSquash fetches remote ref tip and writes it's tree as a single root commit,
+// so that upstream history is not carried into the new repository
+func initWithRemoteSquash(dir, remote, ref, message string, identity *CommitIdentity) error {
+	if ref == "" {
+		ref = "master"
+	}
+	if message == "" {
+		message = fmt.Sprintf("Import %s@%s", maskAuth(remote), ref)
+	}
+	err := initBare(dir)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: []string{"git", "fetch", "-n", "--depth", "1", remote, ref},
+	}
+	gitDebug(&cmd)
+	err = cmd.Run()
+	if err != nil {
+		log.Printf("`git fetch` failed: %v", err)
+		return err
+	}
+	tree, err := gitOutput(dir, "rev-parse", "--verify", "FETCH_HEAD^{tree}")
+	if err != nil {
+		return fmt.Errorf("Unable to determine `%s` ref `%s` tree: %v", maskAuth(remote), ref, err)
+	}
+	commit, err := gitOutputEnv(dir, identity.env(), "commit-tree", tree, "-m", message)
+	if err != nil {
+		return fmt.Errorf("Unable to create squashed commit: %v", err)
+	}
+	_, err = gitOutput(dir, "update-ref", "refs/heads/master", commit)
+	if err != nil {
+		return fmt.Errorf("Unable to update `master` to squashed commit: %v", err)
+	}
+	// drop fetched upstream commit so that it cannot be retrieved by hash
+	for _, file := range []string{"shallow", "FETCH_HEAD"} {
+		err = os.Remove(filepath.Join(dir, file))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Unable to remove `%s`: %v", file, err)
+		}
+	}
+	_, err = gitOutput(dir, "gc", "-q", "--prune=now")
+	if err != nil {
+		log.Printf("Unable to prune upstream history: %v", err)
+	}
+	return nil
+}
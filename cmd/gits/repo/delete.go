@@ -9,7 +9,16 @@ import (
 )
 
 func Delete(repoId string) error {
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	dir := filepath.Join(config.RepoDir, repoId)
+	if config.TrashRetention > 0 {
+		return trash(repoId, dir)
+	}
 	return deleteDir(dir)
 }
 
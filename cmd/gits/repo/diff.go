@@ -0,0 +1,195 @@
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+type FileChange struct {
+	Path      string `json:"path"`
+	OldPath   string `json:"oldPath,omitempty"`
+	Status    string `json:"status"` // added, modified, deleted, renamed, copied, typechanged
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+type Comparison struct {
+	Base      string       `json:"base"`
+	Head      string       `json:"head"`
+	MergeBase string       `json:"mergeBase"`
+	Commits   []LogEntry   `json:"commits"`
+	Files     []FileChange `json:"files"`
+	Patch     string       `json:"patch,omitempty"`
+}
+
+type CommitDiff struct {
+	LogEntry
+	Files []FileChange `json:"files"`
+	Patch string       `json:"patch,omitempty"`
+}
+
+const (
+	emptyTree         = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	compareCommitsMax = 250
+)
+
+var changeStatuses = map[byte]string{
+	'A': "added",
+	'M': "modified",
+	'D': "deleted",
+	'R': "renamed",
+	'C': "copied",
+	'T': "typechanged",
+}
+
+// resolveCommit returns commit id of a full commit hash, branch, or tag
+func resolveCommit(dir, ref string) (string, error) {
+	commit := ref
+	if !guessIsCommitHash(ref) {
+		if strings.HasPrefix(ref, "-") {
+			return "", fmt.Errorf("Ref `%s` is not supported", ref)
+		}
+		var err error
+		commit, _, err = commitByRef(dir, ref)
+		if err != nil {
+			return "", err
+		}
+	}
+	// annotated tag is peeled to commit
+	commit, err := gitOutput(dir, "rev-parse", "-q", "--verify", commit+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Commit `%s` not found", ref)
+	}
+	return commit, nil
+}
+
+// diff returns changed files between two commits, with unified patch if requested
+func diff(dir, from, to string, patch bool) ([]FileChange, string, error) {
+	output, err := gitOutput(dir, "diff", "-z", "-M", "--name-status", from, to)
+	if err != nil {
+		return nil, "", err
+	}
+	files := make([]FileChange, 0)
+	fields := strings.Split(output, "\x00")
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == "" {
+			continue
+		}
+		status, exist := changeStatuses[fields[i][0]]
+		if !exist {
+			status = "unknown"
+		}
+		change := FileChange{Status: status}
+		if (fields[i][0] == 'R' || fields[i][0] == 'C') && i+2 < len(fields) {
+			change.OldPath = fields[i+1]
+			change.Path = fields[i+2]
+			i += 2
+		} else if i+1 < len(fields) {
+			change.Path = fields[i+1]
+			i++
+		}
+		files = append(files, change)
+	}
+
+	// <added> TAB <deleted> TAB <path> NUL, or for renames
+	// <added> TAB <deleted> TAB NUL <old path> NUL <new path> NUL
+	output, err = gitOutput(dir, "diff", "-z", "-M", "--numstat", from, to)
+	if err != nil {
+		return nil, "", err
+	}
+	stats := make(map[string][]string)
+	fields = strings.Split(output, "\x00")
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(fields[i], "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		path := parts[2]
+		if path == "" && i+2 < len(fields) {
+			path = fields[i+2]
+			i += 2
+		}
+		stats[path] = parts[:2]
+	}
+	for i, change := range files {
+		stat, exist := stats[change.Path]
+		if !exist {
+			continue
+		}
+		if stat[0] == "-" {
+			files[i].Binary = true
+			continue
+		}
+		files[i].Additions, _ = strconv.Atoi(stat[0])
+		files[i].Deletions, _ = strconv.Atoi(stat[1])
+	}
+
+	unified := ""
+	if patch {
+		// patch must not depend on diff drivers and colors configured in repository or user config
+		unified, err = gitRawOutput(dir, "diff", "--no-ext-diff", "--no-textconv", "--no-color", "-M", from, to)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return files, unified, nil
+}
+
+// Compare returns changes on `head` since it diverged from `base`, as in `git diff base...head`
+func Compare(repoId, base, head string, patch bool) (*Comparison, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	baseCommit, err := resolveCommit(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := resolveCommit(dir, head)
+	if err != nil {
+		return nil, err
+	}
+	mergeBase, err := gitOutput(dir, "merge-base", baseCommit, headCommit)
+	if err != nil {
+		return nil, fmt.Errorf("Common ancestor of `%s` and `%s` not found", base, head)
+	}
+
+	commits, err := logEntries(dir, "--max-count="+strconv.Itoa(compareCommitsMax), mergeBase+".."+headCommit, "--")
+	if err != nil {
+		return nil, err
+	}
+	files, unified, err := diff(dir, mergeBase, headCommit, patch)
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Base: baseCommit, Head: headCommit, MergeBase: mergeBase,
+		Commits: commits, Files: files, Patch: unified}, nil
+}
+
+// Commit returns commit with changes against it's first parent
+func Commit(repoId, ref string, patch bool) (*CommitDiff, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	commit, err := resolveCommit(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := logEntries(dir, "--max-count=1", commit, "--")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("Commit `%s` not found", ref)
+	}
+	entry := entries[0]
+	parent := emptyTree
+	if len(entry.Parents) > 0 {
+		parent = entry.Parents[0]
+	}
+	files, unified, err := diff(dir, parent, commit, patch)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitDiff{LogEntry: entry, Files: files, Patch: unified}, nil
+}
@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a\n")
+	commitFile(t, work, "old.txt", "content\n")
+	git(t, work, "checkout", "-q", "-b", "feature")
+	git(t, work, "mv", "old.txt", "new.txt")
+	git(t, work, "commit", "-q", "-m", "Rename")
+	c := commitFile(t, work, "README", "a\nc\n")
+	git(t, work, "checkout", "-q", "master")
+	commitFile(t, work, "other", "b\n")
+	git(t, work, "tag", "-a", "-m", "Release", "v1", a)
+	git(t, work, "checkout", "-q", "--orphan", "unrelated")
+	commitFile(t, work, "unrelated", "u\n")
+	bareRepo(t, "diff/compare-1", work)
+
+	comparison, err := Compare("diff/compare-1", "master", "feature", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison.Head != c || comparison.MergeBase != git(t, work, "rev-parse", "feature~2") {
+		t.Errorf("Unexpected head %s / merge base %s", comparison.Head, comparison.MergeBase)
+	}
+	if len(comparison.Commits) != 2 || comparison.Commits[0].Commit != c {
+		t.Errorf("Expected 2 commits on feature, got %+v", comparison.Commits)
+	}
+	files := make(map[string]FileChange)
+	for _, file := range comparison.Files {
+		files[file.Path] = file
+	}
+	if len(files) != 2 || files["new.txt"].Status != "renamed" || files["new.txt"].OldPath != "old.txt" ||
+		files["README"].Status != "modified" || files["README"].Additions != 1 {
+		t.Errorf("Unexpected files %+v", comparison.Files)
+	}
+	if !strings.Contains(comparison.Patch, "+c\n") || !strings.HasSuffix(comparison.Patch, "\n") {
+		t.Errorf("Unexpected patch %q", comparison.Patch)
+	}
+
+	// annotated tag is peeled to commit
+	comparison, err = Compare("diff/compare-1", "v1", "master", false)
+	if err != nil || comparison.Base != a || comparison.MergeBase != a || comparison.Patch != "" {
+		t.Errorf("Unexpected comparison with tag %+v: %v", comparison, err)
+	}
+
+	for _, refs := range [][]string{{"master", "unrelated"}, {"master", "missing"}, {"-x", "master"}} {
+		_, err = Compare("diff/compare-1", refs[0], refs[1], false)
+		if err == nil || !(strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "not supported")) {
+			t.Errorf("Expected client error comparing %v, got %v", refs, err)
+		}
+	}
+}
+
+func TestCommit(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a\n")
+	b := commitFile(t, work, "bin", "\x00\x01")
+	bareRepo(t, "diff/commit-1", work)
+
+	root, err := Commit("diff/commit-1", a, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Parents) != 0 || len(root.Files) != 1 || root.Files[0].Status != "added" || root.Files[0].Additions != 1 {
+		t.Errorf("Unexpected root commit %+v", root)
+	}
+	commit, err := Commit("diff/commit-1", "master", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Commit != b || len(commit.Files) != 1 || !commit.Files[0].Binary || commit.Patch != "" {
+		t.Errorf("Unexpected commit %+v", commit)
+	}
+	_, err = Commit("diff/commit-1", strings.Repeat("0", 40), false)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected missing commit not found, got %v", err)
+	}
+}
+
+func TestCommitPatchApplies(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "x\n\n")
+	commitFile(t, work, "README", "y\n\n")
+	dir := bareRepo(t, "diff/commit-2", work)
+	// config must not change the patch
+	git(t, dir, "config", "color.diff", "always")
+	git(t, dir, "config", "diff.external", "false")
+
+	commit, err := Commit("diff/commit-2", "master", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// last changed line is followed by blank context line
+	if !strings.HasSuffix(commit.Patch, "-x\n+y\n \n") {
+		t.Fatalf("Unexpected patch %q", commit.Patch)
+	}
+	git(t, work, "checkout", "-q", "master~1")
+	patch := filepath.Join(tempDir(t), "patch")
+	err = ioutil.WriteFile(patch, []byte(commit.Patch), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, work, "apply", "--check", patch)
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func init() {
+	repoDir, err := ioutil.TempDir("", "gits-test-")
+	if err != nil {
+		panic(err)
+	}
+	config.RepoDir = repoDir
+	config.LockTimeout = 2 * time.Second
+	// server-side git commands must not depend on user's git config
+	home, err := ioutil.TempDir("", "gits-home-")
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(home, ".gitconfig"),
+		[]byte("[user]\n\tname = Gits Test\n\temail = gits@example.com\n"), 0644)
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+}
+
+// git runs git in `dir` and returns trimmed stdout
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := cmd.Output()
+	if err != nil {
+		stderr := ""
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = string(exitErr.Stderr)
+		}
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, stderr)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// tempDir creates directory removed after the test
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gits-work-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// workRepo creates non-bare repository with `master` branch
+func workRepo(t *testing.T) string {
+	t.Helper()
+	dir := tempDir(t)
+	git(t, dir, "init", "-q")
+	git(t, dir, "symbolic-ref", "HEAD", "refs/heads/master")
+	return dir
+}
+
+// commitFile writes the file and commits it, returns commit id
+func commitFile(t *testing.T, dir, file, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, file)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", file)
+	git(t, dir, "commit", "-q", "-m", "Update "+file)
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+// bareRepo clones work repo into <repo_dir>/<repoId>
+func bareRepo(t *testing.T, repoId, work string) string {
+	t.Helper()
+	dir := filepath.Join(config.RepoDir, repoId)
+	os.RemoveAll(dir)
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, work, "clone", "-q", "--bare", work, dir)
+	return dir
+}
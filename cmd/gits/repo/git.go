@@ -1,10 +1,13 @@
 package repo
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
@@ -27,6 +30,67 @@ func gitSubCommandBinPath(command string) string {
 	return path
 }
 
+// gitProtocolEnv returns process environment with GIT_PROTOCOL set, or nil to inherit environment as is
+func gitProtocolEnv(protocol string) []string {
+	if protocol == "" {
+		return nil
+	}
+	return append(os.Environ(), "GIT_PROTOCOL="+protocol)
+}
+
+// ProtocolVersion returns Git wire protocol version requested by client in GIT_PROTOCOL / Git-Protocol
+func ProtocolVersion(protocol string) int {
+	for _, param := range strings.Split(protocol, ":") {
+		if strings.HasPrefix(param, "version=") {
+			version, err := strconv.Atoi(strings.TrimPrefix(param, "version="))
+			if err == nil {
+				return version
+			}
+		}
+	}
+	return 0
+}
+
+// gitOutput runs git in `dir` and returns trimmed stdout
+func gitOutput(dir string, args ...string) (string, error) {
+	return gitOutputEnv(dir, nil, args...)
+}
+
+// gitOutputEnv is gitOutput with extra environment variables
+func gitOutputEnv(dir string, env []string, args ...string) (string, error) {
+	var stdoutBuffer bytes.Buffer
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: append([]string{"git"}, args...),
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	gitDebug2(&cmd, &stdoutBuffer)
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(stdoutBuffer.String()), nil
+}
+
+// gitRawOutput is gitOutput that returns stdout as is, without trimming whitespace
+func gitRawOutput(dir string, args ...string) (string, error) {
+	var stdoutBuffer bytes.Buffer
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: append([]string{"git"}, args...),
+	}
+	gitDebug2(&cmd, &stdoutBuffer)
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return stdoutBuffer.String(), nil
+}
+
 func printGitArgs(cmd *exec.Cmd) {
 	log.Printf("%s (%s)", strings.Join(cmd.Args, " "), cmd.Dir)
 }
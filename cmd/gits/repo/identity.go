@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+// CommitIdentity overrides server default identity on API commits, author date is RFC 3339
+type CommitIdentity struct {
+	AuthorName     string `json:"authorName,omitempty"`
+	AuthorEmail    string `json:"authorEmail,omitempty"`
+	CommitterName  string `json:"committerName,omitempty"`
+	CommitterEmail string `json:"committerEmail,omitempty"`
+	Date           string `json:"date,omitempty"`
+}
+
+func (id *CommitIdentity) Validate() error {
+	if id == nil {
+		return nil
+	}
+	for _, value := range []string{id.AuthorName, id.AuthorEmail, id.CommitterName, id.CommitterEmail} {
+		if strings.ContainsAny(value, "<>\n\x00") {
+			return fmt.Errorf("Identity `%s` is not supported", value)
+		}
+	}
+	if id.Date != "" {
+		if _, err := time.Parse(time.RFC3339, id.Date); err != nil {
+			return fmt.Errorf("Date `%s` is not supported, must be RFC 3339", id.Date)
+		}
+	}
+	return nil
+}
+
+// env returns Git environment for commit: committer defaults to server identity,
+// author defaults to committer
+func (id *CommitIdentity) env() []string {
+	if id == nil {
+		id = &CommitIdentity{}
+	}
+	committerName := firstNonEmpty(id.CommitterName, config.CommitName)
+	committerEmail := firstNonEmpty(id.CommitterEmail, config.CommitEmail)
+	authorName := firstNonEmpty(id.AuthorName, committerName)
+	authorEmail := firstNonEmpty(id.AuthorEmail, committerEmail)
+
+	env := make([]string, 0, 5)
+	for _, v := range []struct{ name, value string }{
+		{"GIT_AUTHOR_NAME", authorName},
+		{"GIT_AUTHOR_EMAIL", authorEmail},
+		{"GIT_COMMITTER_NAME", committerName},
+		{"GIT_COMMITTER_EMAIL", committerEmail},
+		{"GIT_AUTHOR_DATE", id.Date},
+	} {
+		// empty value falls back to Git config
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value)
+		}
+	}
+	return env
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
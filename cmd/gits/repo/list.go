@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+type RepoInfo struct {
+	Id            string      `json:"id"`
+	TemplateId    string      `json:"templateId,omitempty"`
+	Size          int64       `json:"size"`
+	DefaultBranch string      `json:"defaultBranch,omitempty"`
+	LastCommit    *RepoStatus `json:"lastCommit,omitempty"`
+}
+
+type RepoList struct {
+	Repositories []RepoInfo `json:"repositories"`
+	Total        int        `json:"total"`
+	Offset       int        `json:"offset"`
+	Limit        int        `json:"limit"`
+}
+
+// serviceDir returns true for entries under repo dir that are not organizations / repositories,
+// ie. `_maintenance`, `_webhooks.json`
+func serviceDir(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
+func subDirs(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !serviceDir(entry.Name()) {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// repoIds returns sorted ids of repositories in organization, or in all organizations if `org` is empty
+func repoIds(org string) ([]string, error) {
+	orgs := []string{org}
+	if org == "" {
+		var err error
+		orgs, err = subDirs(config.RepoDir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list `%s`: %v", config.RepoDir, err)
+		}
+	}
+	ids := make([]string, 0)
+	for _, org := range orgs {
+		orgDir := filepath.Join(config.RepoDir, org)
+		repos, err := subDirs(orgDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("Unable to list `%s`: %v", orgDir, err)
+		}
+		for _, repo := range repos {
+			// bare repository has HEAD at the top
+			_, err := os.Stat(filepath.Join(orgDir, repo, "HEAD"))
+			if err == nil {
+				ids = append(ids, org+"/"+repo)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func List(org string, offset, limit int) (*RepoList, error) {
+	ids, err := repoIds(org)
+	if err != nil {
+		return nil, err
+	}
+	list := &RepoList{Repositories: []RepoInfo{}, Total: len(ids), Offset: offset, Limit: limit}
+	if offset >= len(ids) {
+		return list, nil
+	}
+	ids = ids[offset:]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	for _, repoId := range ids {
+		info, err := repoInfo(repoId)
+		if err != nil {
+			return nil, err
+		}
+		list.Repositories = append(list.Repositories, *info)
+	}
+	return list, nil
+}
+
+func repoInfo(repoId string) (*RepoInfo, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	info := &RepoInfo{Id: repoId}
+
+	templateId, err := TemplateId(repoId)
+	if err == nil {
+		info.TemplateId = templateId
+	}
+
+	info.Size, err = dirSize(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to calculate `%s` size: %v", repoId, err)
+	}
+
+	head, err := gitOutput(dir, "symbolic-ref", "-q", "HEAD")
+	if err == nil && strings.HasPrefix(head, "refs/heads/") {
+		info.DefaultBranch = strings.TrimPrefix(head, "refs/heads/")
+		// empty repo has no commits on default branch
+		status, err := Status(repoId, head)
+		if err == nil {
+			info.LastCommit = status
+		}
+	}
+
+	return info, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, file os.FileInfo, err error) error {
+		if err != nil {
+			// repo may be modified concurrently
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if file.Mode().IsRegular() {
+			size += file.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Mutating operations on a repository are serialized by in-process per-repo lock. With -lock_files the
+   lock is also taken on <repo_dir>/_locks/<org>/<repo>.lock with flock(2) to serialize multiple Git
+   Service processes sharing the repo dir. Operations that only read the repository, but must not see it
+   half-updated, ie. copy to a new repository, take the lock shared. */
+
+const (
+	locksDir         = "_locks"
+	lockPollInterval = 50 * time.Millisecond
+)
+
+type repoLock struct {
+	refs      int
+	shared    int
+	exclusive bool
+	// closed and replaced on every release to wake up waiters
+	released chan struct{}
+}
+
+var (
+	repoLocksLock sync.Mutex
+	repoLocks     = make(map[string]*repoLock)
+)
+
+func lockedError(repoId string) error {
+	return fmt.Errorf("Repository `%s` is locked by another operation, try again later", repoId)
+}
+
+// acquireRepoLock must be called with repoLocksLock held
+func acquireRepoLock(repoId string) *repoLock {
+	lock, exist := repoLocks[repoId]
+	if !exist {
+		lock = &repoLock{released: make(chan struct{})}
+		repoLocks[repoId] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// releaseRepoLock must be called with repoLocksLock held
+func releaseRepoLock(repoId string, lock *repoLock) {
+	lock.refs--
+	if lock.refs == 0 {
+		delete(repoLocks, repoId)
+	}
+}
+
+// lockRepo waits up to -lock_timeout for exclusive access to the repository and returns unlock func
+func lockRepo(repoId string) (func(), error) {
+	return lockRepoMode(repoId, true)
+}
+
+// lockRepoShared waits up to -lock_timeout for the repository to be not locked exclusively,
+// other shared locks are allowed
+func lockRepoShared(repoId string) (func(), error) {
+	return lockRepoMode(repoId, false)
+}
+
+func lockRepoMode(repoId string, exclusive bool) (func(), error) {
+	deadline := time.Now().Add(config.LockTimeout)
+	timer := time.NewTimer(config.LockTimeout)
+	defer timer.Stop()
+
+	repoLocksLock.Lock()
+	lock := acquireRepoLock(repoId)
+	for lock.exclusive || (exclusive && lock.shared > 0) {
+		released := lock.released
+		repoLocksLock.Unlock()
+		select {
+		case <-released:
+			repoLocksLock.Lock()
+		case <-timer.C:
+			repoLocksLock.Lock()
+			releaseRepoLock(repoId, lock)
+			repoLocksLock.Unlock()
+			return nil, lockedError(repoId)
+		}
+	}
+	if exclusive {
+		lock.exclusive = true
+	} else {
+		lock.shared++
+	}
+	repoLocksLock.Unlock()
+
+	release := func() {
+		repoLocksLock.Lock()
+		defer repoLocksLock.Unlock()
+		if exclusive {
+			lock.exclusive = false
+		} else {
+			lock.shared--
+		}
+		close(lock.released)
+		lock.released = make(chan struct{})
+		releaseRepoLock(repoId, lock)
+	}
+
+	var file *os.File
+	if config.LockFiles {
+		var err error
+		file, err = lockFile(repoId, exclusive, deadline)
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if file != nil {
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+		}
+		release()
+	}, nil
+}
+
+func lockFile(repoId string, exclusive bool, deadline time.Time) (*os.File, error) {
+	filename := filepath.Join(config.RepoDir, locksDir, repoId+".lock")
+	err := os.MkdirAll(filepath.Dir(filename), dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create `%s` lock directory: %v", filepath.Dir(filename), err)
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open `%s` lock file: %v", filename, err)
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return file, nil
+		}
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			file.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, lockedError(repoId)
+			}
+			return nil, fmt.Errorf("Unable to lock `%s`: %v", filename, err)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
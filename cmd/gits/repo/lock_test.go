@@ -0,0 +1,174 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func expectLocked(t *testing.T, err error, what string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("Expected %s to be locked, got %v", what, err)
+	}
+}
+
+func withLockFiles(t *testing.T, lockFiles bool) {
+	timeout := config.LockTimeout
+	config.LockFiles = lockFiles
+	config.LockTimeout = 200 * time.Millisecond
+	t.Cleanup(func() {
+		config.LockFiles = false
+		config.LockTimeout = timeout
+	})
+}
+
+func TestLockRepo(t *testing.T) {
+	for _, lockFiles := range []bool{false, true} {
+		withLockFiles(t, lockFiles)
+
+		unlock, err := lockRepo("lock/a-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = lockRepo("lock/a-1")
+		expectLocked(t, err, "exclusive lock")
+		_, err = lockRepoShared("lock/a-1")
+		expectLocked(t, err, "shared lock")
+		unlock2, err := lockRepo("lock/b-1")
+		if err != nil {
+			t.Errorf("Expected other repository to be unlocked: %v", err)
+		} else {
+			unlock2()
+		}
+
+		// waiter gets the lock as soon as it's released
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			unlock()
+		}()
+		unlock, err = lockRepo("lock/a-1")
+		if err != nil {
+			t.Fatalf("Expected lock to be taken after release: %v", err)
+		}
+		unlock()
+
+		shared1, err := lockRepoShared("lock/a-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		shared2, err := lockRepoShared("lock/a-1")
+		if err != nil {
+			t.Errorf("Expected shared locks to not conflict: %v", err)
+		} else {
+			shared2()
+		}
+		_, err = lockRepo("lock/a-1")
+		expectLocked(t, err, "exclusive lock while shared lock is held")
+		shared1()
+
+		unlock, err = lockRepo("lock/a-1")
+		if err != nil {
+			t.Errorf("Expected lock after all shared locks are released: %v", err)
+		} else {
+			unlock()
+		}
+
+		repoLocksLock.Lock()
+		left := len(repoLocks)
+		repoLocksLock.Unlock()
+		if left != 0 {
+			t.Errorf("Expected no in-process locks left, got %d", left)
+		}
+	}
+}
+
+// another process is simulated by separate open file description, flock(2) locks conflict across them
+func TestLockRepoFile(t *testing.T) {
+	withLockFiles(t, true)
+	filename := filepath.Join(config.RepoDir, locksDir, "lock/file-1.lock")
+	os.MkdirAll(filepath.Dir(filename), 0755)
+	other, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	err = syscall.Flock(int(other.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockRepoShared("lock/file-1")
+	expectLocked(t, err, "shared lock while other process holds exclusive lock")
+	syscall.Flock(int(other.Fd()), syscall.LOCK_UN)
+
+	err = syscall.Flock(int(other.Fd()), syscall.LOCK_SH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockRepoShared("lock/file-1")
+	if err != nil {
+		t.Errorf("Expected shared lock while other process holds shared lock: %v", err)
+	} else {
+		unlock()
+	}
+	_, err = lockRepo("lock/file-1")
+	expectLocked(t, err, "exclusive lock while other process holds shared lock")
+
+	// released by the other process while waiting
+	fd := int(other.Fd())
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(60 * time.Millisecond)
+		syscall.Flock(fd, syscall.LOCK_UN)
+		close(released)
+	}()
+	unlock, err = lockRepo("lock/file-1")
+	<-released
+	if err != nil {
+		t.Fatalf("Expected exclusive lock after other process released it: %v", err)
+	}
+	err = syscall.Flock(int(other.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err != syscall.EWOULDBLOCK {
+		t.Errorf("Expected lock file to be locked exclusively, got %v", err)
+	}
+	unlock()
+	err = syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		t.Errorf("Expected lock file to be unlocked: %v", err)
+	}
+}
+
+func TestCreateLocksSource(t *testing.T) {
+	withLockFiles(t, false)
+	work := workRepo(t)
+	commitFile(t, work, "README", "a")
+	bareRepo(t, "lock/source-1", work)
+
+	unlock, err := lockRepo("lock/source-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Create("lock/copy-1", &CreateRequest{Source: "lock/source-1"})
+	expectLocked(t, err, "source being updated")
+	if Exist("lock/copy-1") {
+		t.Error("Expected failed copy to leave no repository")
+	}
+	unlock()
+
+	// concurrent copies of the same source do not conflict
+	shared, err := lockRepoShared("lock/source-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared()
+	err = Create("lock/copy-1", &CreateRequest{Source: "lock/source-1"})
+	if err != nil {
+		t.Errorf("Expected copy while source is locked shared: %v", err)
+	}
+}
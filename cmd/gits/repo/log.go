@@ -1,25 +1,163 @@
 package repo
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
 )
 
-func Log(repoId, ref string) ([]byte, error) {
+type LogOptions struct {
+	Limit int    // 0 for no limit
+	Skip  int    // commits to skip
+	After string // continue from parents of the commit, ie. the last commit of the previous page
+	Since string // date in any format understood by Git
+	Path  string // commits touching the path only
+}
+
+type LogEntry struct {
+	Commit     string   `json:"commit"`
+	Parents    []string `json:"parents"`
+	Author     string   `json:"author"`
+	AuthorDate string   `json:"authorDate"`
+	Committer  string   `json:"committer"`
+	CommitDate string   `json:"commitDate"`
+	Subject    string   `json:"subject"`
+	Body       string   `json:"body,omitempty"`
+}
+
+func logArgs(revisions []string, opts *LogOptions) []string {
+	args := []string{}
+	if opts != nil {
+		if opts.Limit > 0 {
+			args = append(args, "--max-count="+strconv.Itoa(opts.Limit))
+		}
+		if opts.Skip > 0 {
+			args = append(args, "--skip="+strconv.Itoa(opts.Skip))
+		}
+		if opts.Since != "" {
+			args = append(args, "--since="+opts.Since)
+		}
+	}
+	args = append(append(args, revisions...), "--")
+	if opts != nil && opts.Path != "" {
+		args = append(args, opts.Path)
+	}
+	return args
+}
+
+// checkLogRef verifies ref is a commit and cannot be mistaken by Git for an option
+func checkLogRef(dir, ref string) (string, error) {
 	if ref == "" {
 		ref = "master"
 	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Ref `%s` is not supported", ref)
+	}
+	_, err := gitOutput(dir, "rev-parse", "-q", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Ref `%s` not found", ref)
+	}
+	return ref, nil
+}
+
+// logStart returns revisions to start the log from: `ref`, or parents of `opts.After` commit that must be reachable
+// from `ref`; the list is empty if the commit has no parents and there is nothing to log
+func logStart(dir, ref string, opts *LogOptions) ([]string, error) {
+	ref, err := checkLogRef(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil || opts.After == "" {
+		return []string{ref}, nil
+	}
+	after, err := checkLogRef(dir, opts.After)
+	if err != nil {
+		return nil, err
+	}
+	reachable, err := isFastForward(dir, nil, after, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !reachable {
+		return nil, fmt.Errorf("Commit `%s` not found on `%s`", after, ref)
+	}
+	parents, err := gitOutput(dir, "rev-parse", after+"^@")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(parents), nil
+}
+
+func Log(repoId, ref string, opts *LogOptions) ([]byte, error) {
 	dir := filepath.Join(config.RepoDir, repoId)
+	revisions, err := logStart(dir, ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return []byte{}, nil
+	}
 	cmd := exec.Cmd{
 		Path: gitBinPath(),
 		Dir:  dir,
-		Args: []string{"git", "log", ref},
+		Args: append([]string{"git", "log"}, logArgs(revisions, opts)...),
 	}
 	if config.Debug {
 		cmd.Stderr = os.Stdout
 	}
 	return cmd.Output()
 }
+
+const (
+	logRecordSep = "\x1e"
+	logFieldSep  = "\x1f"
+)
+
+func LogEntries(repoId, ref string, opts *LogOptions) ([]LogEntry, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	revisions, err := logStart(dir, ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return []LogEntry{}, nil
+	}
+	return logEntries(dir, logArgs(revisions, opts)...)
+}
+
+func logEntries(dir string, args ...string) ([]LogEntry, error) {
+	fields := []string{"%H", "%P", "%an <%ae>", "%aI", "%cn <%ce>", "%cI", "%s", "%b"}
+	format := "--format=" + logRecordSep + strings.Join(fields, logFieldSep)
+	output, err := gitOutput(dir, append([]string{"log", format}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LogEntry, 0)
+	for _, record := range strings.Split(output, logRecordSep) {
+		parts := strings.Split(record, logFieldSep)
+		if len(parts) != len(fields) {
+			continue
+		}
+		parents := strings.Fields(parts[1])
+		if parents == nil {
+			parents = []string{}
+		}
+		entries = append(entries, LogEntry{
+			Commit:     parts[0],
+			Parents:    parents,
+			Author:     parts[2],
+			AuthorDate: parts[3],
+			Committer:  parts[4],
+			CommitDate: parts[5],
+			Subject:    parts[6],
+			Body:       strings.TrimSpace(parts[7]),
+		})
+	}
+	return entries, nil
+}
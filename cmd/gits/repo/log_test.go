@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a\n")
+	b := commitFile(t, work, "docs/guide", "b\n")
+	git(t, work, "commit", "-q", "--allow-empty", "-m", "Subject", "-m", "Body line")
+	c := git(t, work, "rev-parse", "HEAD")
+	bareRepo(t, "log/log-1", work)
+
+	entries, err := LogEntries("log/log-1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Commit != c || entries[2].Commit != a {
+		t.Fatalf("Unexpected log %+v", entries)
+	}
+	if entries[0].Subject != "Subject" || entries[0].Body != "Body line" ||
+		len(entries[0].Parents) != 1 || entries[0].Parents[0] != b ||
+		len(entries[2].Parents) != 0 || entries[2].Author != "Test <test@example.com>" {
+		t.Errorf("Unexpected log entries %+v", entries)
+	}
+
+	entries, err = LogEntries("log/log-1", "master", &LogOptions{Limit: 1, Skip: 1})
+	if err != nil || len(entries) != 1 || entries[0].Commit != b {
+		t.Errorf("Unexpected log with limit and skip %+v: %v", entries, err)
+	}
+	entries, err = LogEntries("log/log-1", "master", &LogOptions{Path: "docs"})
+	if err != nil || len(entries) != 1 || entries[0].Commit != b {
+		t.Errorf("Unexpected log of path %+v: %v", entries, err)
+	}
+
+	text, err := Log("log/log-1", "master", &LogOptions{Path: "README"})
+	if err != nil || !strings.HasPrefix(string(text), "commit "+a) {
+		t.Errorf("Unexpected text log %q: %v", text, err)
+	}
+}
+
+func TestLogRef(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "a\n")
+	dir := bareRepo(t, "log/log-2", work)
+	output := filepath.Join(tempDir(t), "output")
+
+	for _, ref := range []string{"--output=" + output, "-p", "-"} {
+		_, err := Log("log/log-2", ref, nil)
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("Expected ref %q to be not supported by text log, got %v", ref, err)
+		}
+		_, err = LogEntries("log/log-2", ref, nil)
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("Expected ref %q to be not supported by log entries, got %v", ref, err)
+		}
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Errorf("Log wrote %s", output)
+	}
+
+	git(t, dir, "tag", "v1", "master^{tree}")
+	for _, ref := range []string{"missing", "v1"} {
+		_, err := Log("log/log-2", ref, nil)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected ref %q to be not found by text log, got %v", ref, err)
+		}
+		_, err = LogEntries("log/log-2", ref, nil)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected ref %q to be not found by log entries, got %v", ref, err)
+		}
+	}
+}
+
+func TestLogAfter(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a\n")
+	b := commitFile(t, work, "README", "b\n")
+	c := commitFile(t, work, "README", "c\n")
+	d := commitFile(t, work, "README", "d\n")
+	git(t, work, "checkout", "-q", "-b", "other", a)
+	other := commitFile(t, work, "other", "o\n")
+	bareRepo(t, "log/log-3", work)
+
+	commits := func(entries []LogEntry) string {
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.Commit)
+		}
+		return strings.Join(ids, " ")
+	}
+	for _, test := range []struct {
+		after    string
+		expected []string
+	}{
+		{"", []string{d, c}},
+		{c, []string{b, a}},
+		{b, []string{a}},
+		{a, []string{}},
+	} {
+		entries, err := LogEntries("log/log-3", "master", &LogOptions{Limit: 2, After: test.after})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := commits(entries); found != strings.Join(test.expected, " ") {
+			t.Errorf("Unexpected log after %s: %s", test.after, found)
+		}
+	}
+
+	text, err := Log("log/log-3", "master", &LogOptions{After: b})
+	if err != nil || !strings.HasPrefix(string(text), "commit "+a) {
+		t.Errorf("Unexpected text log %q: %v", text, err)
+	}
+	text, err = Log("log/log-3", "master", &LogOptions{After: a})
+	if err != nil || len(text) != 0 {
+		t.Errorf("Expected empty text log after root commit, got %q: %v", text, err)
+	}
+
+	for after, expected := range map[string]string{other: "not found", "missing": "not found", "-p": "not supported"} {
+		_, err = LogEntries("log/log-3", "master", &LogOptions{After: after})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected `%s` error for commit %s, got %v", expected, after, err)
+		}
+	}
+}
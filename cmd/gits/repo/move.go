@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Moved repositories may leave a redirect in <repo_dir>/_redirects.json so that old HTTP and SSH
+   clone URLs keep working for -redirect_ttl. Redirect is followed only if there is no repository
+   at the old id. */
+
+const redirectsFile = "_redirects.json"
+
+type RepoRedirect struct {
+	To      string    `json:"to"`
+	Expires time.Time `json:"expires"`
+}
+
+var redirectsLock sync.Mutex
+
+func readRedirects() (map[string]RepoRedirect, error) {
+	file := filepath.Join(config.RepoDir, redirectsFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]RepoRedirect), nil
+		}
+		return nil, fmt.Errorf("Unable to read `%s`: %v", file, err)
+	}
+	redirects := make(map[string]RepoRedirect)
+	err = json.Unmarshal(data, &redirects)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal `%s`: %v", file, err)
+	}
+	return redirects, nil
+}
+
+func writeRedirects(redirects map[string]RepoRedirect) error {
+	now := time.Now()
+	for from, redirect := range redirects {
+		if now.After(redirect.Expires) {
+			delete(redirects, from)
+		}
+	}
+	data, err := json.MarshalIndent(redirects, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(config.RepoDir, redirectsFile)
+	temp := file + ".tmp"
+	err = ioutil.WriteFile(temp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to write `%s`: %v", temp, err)
+	}
+	return os.Rename(temp, file)
+}
+
+// Redirected returns id of the repository moved from `repoId` if redirect is not expired
+func Redirected(repoId string) (string, bool) {
+	redirectsLock.Lock()
+	defer redirectsLock.Unlock()
+
+	redirects, err := readRedirects()
+	if err != nil {
+		log.Print(err)
+		return "", false
+	}
+	redirect, exist := redirects[repoId]
+	if !exist || time.Now().After(redirect.Expires) {
+		return "", false
+	}
+	return redirect.To, true
+}
+
+func checkRepoId(repoId string) error {
+	parts := strings.Split(repoId, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+		serviceDir(parts[0]) || serviceDir(parts[1]) {
+		return fmt.Errorf("Repository id `%s` is not supported", repoId)
+	}
+	return nil
+}
+
+// Move relocates repository to `newId`, leaving a redirect from the old id if requested
+func Move(repoId, newId string, redirect bool) error {
+	err := checkRepoId(newId)
+	if err != nil {
+		return err
+	}
+	if newId == repoId {
+		return fmt.Errorf("Repository `%s` already exist", newId)
+	}
+
+	// lock in the same order to not deadlock with concurrent move in opposite direction
+	first, second := repoId, newId
+	if second < first {
+		first, second = second, first
+	}
+	unlock, err := lockRepo(first)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	unlock2, err := lockRepo(second)
+	if err != nil {
+		return err
+	}
+	defer unlock2()
+
+	dir := filepath.Join(config.RepoDir, repoId)
+	newDir := filepath.Join(config.RepoDir, newId)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("Repository `%s` not found", repoId)
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		return fmt.Errorf("Repository `%s` already exist", newId)
+	}
+	err = os.MkdirAll(filepath.Dir(newDir), dirMode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(dir, newDir)
+	if err != nil {
+		return fmt.Errorf("Unable to move `%s` to `%s`: %v", repoId, newId, err)
+	}
+	// remove organization directory if empty
+	os.Remove(filepath.Dir(dir))
+
+	redirectsLock.Lock()
+	defer redirectsLock.Unlock()
+	redirects, err := readRedirects()
+	if err != nil {
+		return err
+	}
+	// repository exist at new id now, and earlier redirects to old id follow the move
+	delete(redirects, newId)
+	for from, r := range redirects {
+		if r.To == repoId {
+			redirects[from] = RepoRedirect{To: newId, Expires: r.Expires}
+		}
+	}
+	if redirect && config.RedirectTtl > 0 {
+		redirects[repoId] = RepoRedirect{To: newId, Expires: time.Now().Add(config.RedirectTtl).UTC()}
+	}
+	err = writeRedirects(redirects)
+	if err != nil {
+		return err
+	}
+
+	if config.Verbose {
+		log.Printf("Repo `%s` moved to `%s`", repoId, newId)
+	}
+	return nil
+}
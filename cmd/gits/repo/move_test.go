@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func TestCheckRepoId(t *testing.T) {
+	for _, repoId := range []string{"org/repo", "org/repo.name-1"} {
+		if err := checkRepoId(repoId); err != nil {
+			t.Errorf("Expected `%s` to be valid: %v", repoId, err)
+		}
+	}
+	for _, repoId := range []string{"org", "org/", "/repo", "org/repo/x", "_trash/repo", "org/_locks", ".git/repo", ""} {
+		if err := checkRepoId(repoId); err == nil {
+			t.Errorf("Expected `%s` to be rejected", repoId)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	config.RedirectTtl = time.Hour
+	defer func() { config.RedirectTtl = 0 }()
+	work := workRepo(t)
+	commitFile(t, work, "README", "a")
+	bareRepo(t, "move/a-1", work)
+	bareRepo(t, "move/taken-1", work)
+
+	if err := Move("move/a-1", "move/taken-1", true); err == nil {
+		t.Error("Expected move over existing repository to fail")
+	}
+	if err := Move("move/missing-1", "move/b-1", true); err == nil {
+		t.Error("Expected move of missing repository to fail")
+	}
+	if err := Move("move/a-1", "_trash/b-1", true); err == nil {
+		t.Error("Expected move to service directory to fail")
+	}
+
+	err := Move("move/a-1", "moved/b-1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Exist("move/a-1") || !Exist("moved/b-1") {
+		t.Error("Expected repository to be moved")
+	}
+	if to, ok := Redirected("move/a-1"); !ok || to != "moved/b-1" {
+		t.Errorf("Expected redirect to `moved/b-1`, got %q %v", to, ok)
+	}
+
+	// earlier redirects follow the repository
+	err = Move("moved/b-1", "moved/c-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to, ok := Redirected("move/a-1"); !ok || to != "moved/c-1" {
+		t.Errorf("Expected redirect to follow to `moved/c-1`, got %q %v", to, ok)
+	}
+	if _, ok := Redirected("moved/b-1"); ok {
+		t.Error("Expected no redirect when not requested")
+	}
+
+	// repository created at old id takes its redirect down
+	err = Move("moved/c-1", "move/a-1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Redirected("move/a-1"); ok {
+		t.Error("Expected redirect to be dropped when repository is moved back")
+	}
+	if to, ok := Redirected("moved/c-1"); !ok || to != "move/a-1" {
+		t.Errorf("Expected redirect to `move/a-1`, got %q %v", to, ok)
+	}
+
+	// expired redirects are not followed
+	err = ioutil.WriteFile(filepath.Join(config.RepoDir, redirectsFile),
+		[]byte(`{"move/expired-1": {"to": "move/a-1", "expires": "2020-01-01T00:00:00Z"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Redirected("move/expired-1"); ok {
+		t.Error("Expected expired redirect to be ignored")
+	}
+}
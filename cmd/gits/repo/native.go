@@ -0,0 +1,365 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* In-process implementation of Git pack protocol, enabled by -native_git.
+   https://github.com/git/git/blob/master/Documentation/technical/pack-protocol.txt
+   Only the subset of capabilities go-git can serve is advertised: no side-band, no shallow,
+   no multi_ack. The negotiation mimics git-upload-pack behaviour in that mode. */
+
+const noThin = capability.Capability("no-thin")
+
+type nativeLoader struct {
+	storer.Storer
+}
+
+func (l nativeLoader) Load(*transport.Endpoint) (storer.Storer, error) {
+	return l.Storer, nil
+}
+
+func nativeStorage(dir string) *filesystem.Storage {
+	return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+}
+
+func nativeRefsInfo(dir string, service string, out io.Writer) error {
+	sto := nativeStorage(dir)
+	ar, err := nativeAdvertisedRefs(sto, service)
+	if err != nil {
+		return err
+	}
+	return ar.Encode(out)
+}
+
+func nativeAdvertisedRefs(sto storer.Storer, service string) (*packp.AdvRefs, error) {
+	srv := server.NewServer(nativeLoader{sto})
+	var session transport.Session
+	var err error
+	switch service {
+	case "git-upload-pack":
+		session, err = srv.NewUploadPackSession(nil, nil)
+	case "git-receive-pack":
+		session, err = srv.NewReceivePackSession(nil, nil)
+	default:
+		err = fmt.Errorf("%q is not supported by native Git server", service)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ar, err := session.AdvertisedReferences()
+	if err != nil {
+		return nil, err
+	}
+	if service == "git-receive-pack" {
+		// go-git cannot resolve thin pack deltas against existing objects
+		err = ar.Capabilities.Set(noThin)
+	}
+	return ar, err
+}
+
+func nativePack(dir string, service string, out io.Writer, in io.Reader, stateless bool) error {
+	sto := nativeStorage(dir)
+	if !stateless {
+		ar, err := nativeAdvertisedRefs(sto, service)
+		if err != nil {
+			return err
+		}
+		err = ar.Encode(out)
+		if err != nil {
+			return err
+		}
+	}
+	// client may send just a flush, ie. `git ls-remote` over SSH
+	input := bufio.NewReader(in)
+	flush, err := input.Peek(4)
+	if err == io.EOF || (err == nil && bytes.Equal(flush, pktline.FlushPkt)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch service {
+	case "git-upload-pack":
+		return nativeUploadPack(sto, out, input, stateless)
+	case "git-receive-pack":
+		return nativeReceivePack(dir, sto, out, input)
+	}
+	return fmt.Errorf("%q is not supported by native Git server", service)
+}
+
+func nativeUploadPack(sto storer.Storer, out io.Writer, in io.Reader, stateless bool) error {
+	req := packp.NewUploadRequest()
+	err := req.Decode(in)
+	if err != nil {
+		return fmt.Errorf("Unable to decode upload-pack request: %v", err)
+	}
+	if len(req.Shallows) > 0 || !req.Depth.IsZero() {
+		return fmt.Errorf("Shallow clone is not supported by native Git server")
+	}
+	err = nativeCheckWants(sto, req.Wants)
+	if err != nil {
+		return err
+	}
+
+	haves := make([]plumbing.Hash, 0)
+	enc := pktline.NewEncoder(out)
+	scanner := pktline.NewScanner(in)
+	for {
+		if !scanner.Scan() {
+			err := scanner.Err()
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\n"))
+		if len(line) == 0 {
+			if len(haves) == 0 {
+				err = enc.Encodef("NAK\n")
+				if err != nil {
+					return err
+				}
+			}
+			if stateless {
+				return nil
+			}
+			continue
+		}
+		if bytes.Equal(line, []byte("done")) {
+			if len(haves) == 0 {
+				err = enc.Encodef("NAK\n")
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if bytes.HasPrefix(line, []byte("have ")) {
+			have := plumbing.NewHash(string(line[5:]))
+			_, err := sto.EncodedObject(plumbing.AnyObject, have)
+			if err != nil {
+				continue
+			}
+			haves = append(haves, have)
+			if len(haves) == 1 {
+				err = enc.Encodef("ACK %s\n", have)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		return fmt.Errorf("Unexpected upload-pack request line %q", line)
+	}
+
+	objects, err := revlist.Objects(sto, req.Wants, haves)
+	if err != nil {
+		return fmt.Errorf("Unable to enumerate objects to pack: %v", err)
+	}
+	if config.Trace {
+		log.Printf("Native upload-pack: %d wants, %d haves, %d objects", len(req.Wants), len(haves), len(objects))
+	}
+	_, err = packfile.NewEncoder(out, sto, false).Encode(objects, 10)
+	return err
+}
+
+// nativeCheckWants verifies wants are advertised refs or objects reachable from them, so that objects left
+// unreferenced by force push or ref deletion could not be fetched; reachability walk is for stateless
+// clients that have seen the refs before they were updated
+func nativeCheckWants(sto storer.Storer, wants []plumbing.Hash) error {
+	ar, err := nativeAdvertisedRefs(sto, "git-upload-pack")
+	if err != nil {
+		return err
+	}
+	tips := make(map[plumbing.Hash]bool)
+	for _, hash := range ar.References {
+		tips[hash] = true
+	}
+	for _, hash := range ar.Peeled {
+		tips[hash] = true
+	}
+	if ar.Head != nil {
+		tips[*ar.Head] = true
+	}
+	other := make([]plumbing.Hash, 0)
+	for _, want := range wants {
+		if !tips[want] {
+			other = append(other, want)
+		}
+	}
+	if len(other) == 0 {
+		return nil
+	}
+	roots := make([]plumbing.Hash, 0, len(tips))
+	for hash := range tips {
+		roots = append(roots, hash)
+	}
+	reachable, err := revlist.Objects(sto, roots, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to enumerate reachable objects: %v", err)
+	}
+	objects := make(map[plumbing.Hash]bool, len(reachable))
+	for _, hash := range reachable {
+		objects[hash] = true
+	}
+	for _, want := range other {
+		if !objects[want] {
+			return fmt.Errorf("Not our ref %v", want)
+		}
+	}
+	return nil
+}
+
+func nativeReceivePack(dir string, sto storer.Storer, out io.Writer, in io.Reader) error {
+	req := packp.NewReferenceUpdateRequest()
+	err := req.Decode(in)
+	if err != nil {
+		if err == packp.ErrEmpty {
+			return nil
+		}
+		return fmt.Errorf("Unable to decode receive-pack request: %v", err)
+	}
+
+	status := packp.NewReportStatus()
+	status.UnpackStatus = "ok"
+
+	needPack := false
+	for _, cmd := range req.Commands {
+		if cmd.Action() != packp.Delete {
+			needPack = true
+		}
+	}
+	var unpackErr error
+	if needPack && req.Packfile != nil {
+		unpackErr = packfile.UpdateObjectStorage(sto, req.Packfile)
+		if unpackErr != nil {
+			status.UnpackStatus = unpackErr.Error()
+		}
+	}
+
+	var firstErr error
+	for _, cmd := range req.Commands {
+		err := unpackErr
+		if err == nil {
+			err = nativeUpdateRef(dir, sto, cmd)
+		}
+		msg := "ok"
+		if err != nil {
+			msg = err.Error()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Unable to update `%s`: %v", cmd.Name, err)
+			}
+		}
+		status.CommandStatuses = append(status.CommandStatuses,
+			&packp.CommandStatus{ReferenceName: cmd.Name, Status: msg})
+	}
+
+	if req.Capabilities.Supports(capability.ReportStatus) {
+		err = status.Encode(out)
+		if err != nil {
+			return err
+		}
+	}
+	if unpackErr != nil {
+		return fmt.Errorf("Unable to unpack: %v", unpackErr)
+	}
+	return firstErr
+}
+
+// nativeUpdateRef updates ref if it's current value still match old value sent by client;
+// the ref file is locked and compared to old value on write to not lose concurrent update
+func nativeUpdateRef(dir string, sto storer.Storer, cmd *packp.Command) error {
+	current, err := sto.Reference(cmd.Name)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+	switch cmd.Action() {
+	case packp.Create:
+		if current != nil {
+			return fmt.Errorf("already exists")
+		}
+	case packp.Delete, packp.Update:
+		if current == nil || current.Hash() != cmd.Old {
+			return fmt.Errorf("stale info")
+		}
+		// compare-and-set works on loose ref file only, while ref might be packed
+		_, err := os.Stat(filepath.Join(dir, cmd.Name.String()))
+		if os.IsNotExist(err) {
+			err = sto.SetReference(current)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("bad command")
+	}
+
+	// empty file of just created ref reads as zero hash
+	old := plumbing.NewHashReference(cmd.Name, cmd.Old)
+	ref := plumbing.NewHashReference(cmd.Name, cmd.New)
+	if cmd.Action() == packp.Delete {
+		ref = old
+	} else {
+		_, err := sto.EncodedObject(plumbing.AnyObject, cmd.New)
+		if err != nil {
+			return fmt.Errorf("missing necessary objects")
+		}
+	}
+	err = sto.CheckAndSetReference(ref, old)
+	if err == storage.ErrReferenceHasChanged {
+		return fmt.Errorf("stale info")
+	}
+	if err != nil {
+		return err
+	}
+	if cmd.Action() == packp.Delete {
+		return sto.RemoveReference(cmd.Name)
+	}
+	return nil
+}
+
+// asyncProcess runs Git server in background and mimics exec.Cmd Wait()
+type asyncProcess struct {
+	done chan error
+}
+
+func (p *asyncProcess) Wait() error {
+	return <-p.done
+}
+
+func startAsync(serve func() error) GitProcess {
+	process := &asyncProcess{done: make(chan error, 1)}
+	go func() {
+		process.done <- serve()
+	}()
+	return process
+}
+
+func startNative(dir string, service string, stdout io.Writer, stdin io.Reader) GitProcess {
+	return startAsync(func() error {
+		return nativePack(dir, service, stdout, stdin, false)
+	})
+}
@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+// nativeServer serves smart HTTP Git protocol over native Git server, repo id is the URL path
+func nativeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	config.NativeGit = true
+	t.Cleanup(func() { config.NativeGit = false })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/info/refs") {
+			service := req.URL.Query().Get("service")
+			repoId := strings.Trim(strings.TrimSuffix(req.URL.Path, "/info/refs"), "/")
+			w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+			announce := fmt.Sprintf("# service=%s\n", service)
+			fmt.Fprintf(w, "%04x%s0000", len(announce)+4, announce)
+			err := RefsInfo(repoId, service, "", w)
+			if err != nil {
+				t.Errorf("Refs info: %v", err)
+			}
+			return
+		}
+		service := filepath.Base(req.URL.Path)
+		repoId := strings.Trim(filepath.Dir(req.URL.Path), "/")
+		w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+		err := pack(repoId, service, "", nil, "http", w, req.Body)
+		if err != nil {
+			t.Logf("Pack %s: %v", service, err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNativeRoundTrip(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a")
+	commitFile(t, work, "README", "b")
+	git(t, work, "branch", "old")
+	dir := bareRepo(t, "native/round-1", work)
+	server := nativeServer(t)
+
+	clone := tempDir(t)
+	git(t, clone, "clone", "-q", server.URL+"/native/round-1", ".")
+	if head := git(t, clone, "rev-parse", "HEAD"); head != git(t, dir, "rev-parse", "master") {
+		t.Fatalf("Clone HEAD is %s", head)
+	}
+
+	// update of packed ref, create, delete, and forced update in one push
+	c := commitFile(t, clone, "README", "c")
+	git(t, clone, "branch", "feature", a)
+	git(t, clone, "push", "-q", "origin", "master", "feature", ":old")
+	if master := git(t, dir, "rev-parse", "master"); master != c {
+		t.Errorf("Expected master at %s, got %s", c, master)
+	}
+	if feature := git(t, dir, "rev-parse", "feature"); feature != a {
+		t.Errorf("Expected feature at %s, got %s", a, feature)
+	}
+	if refs := git(t, dir, "for-each-ref", "refs/heads/old"); refs != "" {
+		t.Errorf("Expected old branch deleted, got %q", refs)
+	}
+	git(t, clone, "push", "-q", "--force", "origin", "feature:master")
+	if master := git(t, dir, "rev-parse", "master"); master != a {
+		t.Errorf("Expected master forced to %s, got %s", a, master)
+	}
+
+	fetched := tempDir(t)
+	git(t, fetched, "clone", "-q", server.URL+"/native/round-1", ".")
+	git(t, fetched, "fetch", "-q", "origin")
+	if head := git(t, fetched, "rev-parse", "origin/feature"); head != a {
+		t.Errorf("Expected fetched feature at %s, got %s", a, head)
+	}
+
+	// unreferenced commit must not be served
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "%04xwant %s\n0000", 4+len("want \n")+40, c)
+	fmt.Fprintf(&req, "%04xdone\n", 4+len("done\n"))
+	var out bytes.Buffer
+	err := nativePack(dir, "git-upload-pack", &out, &req, true)
+	if err == nil || !strings.Contains(err.Error(), "Not our ref") {
+		t.Errorf("Expected unreachable want to be refused, got %v", err)
+	}
+
+	// stale push is rejected
+	var push bytes.Buffer
+	line := fmt.Sprintf("%s %s refs/heads/master\x00report-status\n", c, a)
+	fmt.Fprintf(&push, "%04x%s0000", 4+len(line), line)
+	push.Write(emptyPack)
+	out.Reset()
+	err = nativePack(dir, "git-receive-pack", &out, &push, true)
+	if err == nil || !strings.Contains(out.String(), "ng refs/heads/master stale info") {
+		t.Errorf("Expected stale push to fail, got %q: %v", out.String(), err)
+	}
+}
+
+func TestNativeFlushOnly(t *testing.T) {
+	work := workRepo(t)
+	commitFile(t, work, "README", "a")
+	dir := bareRepo(t, "native/flush-1", work)
+
+	// `git fetch` that is up to date sends just a flush after the advertisement
+	var out bytes.Buffer
+	err := nativePack(dir, "git-upload-pack", &out, strings.NewReader("0000"), false)
+	if err != nil {
+		t.Errorf("Flush-only request failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "refs/heads/master") {
+		t.Errorf("Expected refs advertisement, got %q", out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
+)
+
+// refsSnapshot returns ref name to object id map
+func refsSnapshot(repoId string) (map[string]string, error) {
+	dir := filepath.Join(config.RepoDir, repoId)
+	out, err := gitOutput(dir, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 {
+			refs[parts[1]] = parts[0]
+		}
+	}
+	return refs, nil
+}
+
+// watchRefs returns repo refs snapshot to pass to notifyRefChanges later,
+// or nil if there is no webhook to notify
+func watchRefs(repoId string) map[string]string {
+	if !webhooks.Configured(repoId) {
+		return nil
+	}
+	refs, err := refsSnapshot(repoId)
+	if err != nil {
+		log.Printf("Unable to list `%s` refs: %v", repoId, err)
+		return nil
+	}
+	return refs
+}
+
+// notifyRefChanges sends webhook events for refs changed since watchRefs snapshot was taken
+func notifyRefChanges(repoId string, before map[string]string, users []string, transport string) {
+	if before == nil {
+		return
+	}
+	after, err := refsSnapshot(repoId)
+	if err != nil {
+		log.Printf("Unable to list `%s` refs: %v", repoId, err)
+		return
+	}
+	updates := make([]refUpdate, 0)
+	for ref, old := range before {
+		if after[ref] != old {
+			new, exist := after[ref]
+			if !exist {
+				new = zeroId
+			}
+			updates = append(updates, refUpdate{Old: old, New: new, Ref: ref})
+		}
+	}
+	for ref, new := range after {
+		if _, exist := before[ref]; !exist {
+			updates = append(updates, refUpdate{Old: zeroId, New: new, Ref: ref})
+		}
+	}
+	sendRefUpdates(repoId, updates, users, transport)
+}
+
+// notifyRefUpdates sends webhook events for pushed ref updates that are in effect
+func notifyRefUpdates(repoId string, updates []refUpdate, users []string, transport string) {
+	if len(updates) == 0 || !webhooks.Configured(repoId) {
+		return
+	}
+	current, err := refsSnapshot(repoId)
+	if err != nil {
+		log.Printf("Unable to list `%s` refs: %v", repoId, err)
+		return
+	}
+	applied := make([]refUpdate, 0, len(updates))
+	for _, update := range updates {
+		new, exist := current[update.Ref]
+		if (update.isDelete() && !exist) || (!update.isDelete() && new == update.New) {
+			applied = append(applied, update)
+		}
+	}
+	sendRefUpdates(repoId, applied, users, transport)
+}
+
+func sendRefUpdates(repoId string, updates []refUpdate, users []string, transport string) {
+	for _, update := range updates {
+		webhooks.Notify(webhooks.Event{
+			Repository: repoId,
+			Ref:        update.Ref,
+			Before:     update.Old,
+			After:      update.New,
+			Pusher:     strings.Join(users, ","),
+			Transport:  transport,
+		})
+	}
+}
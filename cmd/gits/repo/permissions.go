@@ -2,16 +2,12 @@ package repo
 
 import (
 	"fmt"
+	"log"
 	"strings"
 
-	"github.com/agilestacks/git-service/cmd/gits/extapi"
+	"github.com/agilestacks/git-service/cmd/gits/config"
 )
 
-type UserAccess struct {
-	UserId   string
-	CanWrite bool
-}
-
 func orgId(repo string) (string, error) {
 	slash := strings.Index(repo, "/")
 	if slash < 1 || slash >= len(repo)-1 {
@@ -28,105 +24,48 @@ func TemplateId(repo string) (string, error) {
 	return repo[dash+1:], nil
 }
 
-func Access(repo string, verb string, users []string) (bool, error) {
-	orgId, err := orgId(repo)
-	if err != nil {
-		return false, err
-	}
-	templateId, err := TemplateId(repo)
-	if err != nil {
-		return false, err
-	}
-
-	org, err := extapi.OrgById(orgId)
-	if err != nil {
-		return false, fmt.Errorf("Unable to fetch organization `%s` info: %v", orgId, err)
-	}
-	if !org.ShowSource {
-		return false, fmt.Errorf("Organization `%s` has no source code access", orgId)
-	}
-
-	template, err := extapi.TemplateById(templateId)
-	if err != nil {
-		return false, fmt.Errorf("Unable to fetch template `%s` info: %v", templateId, err)
-	}
+// AuthProvider resolves users and checks their access to repositories, selected by -auth_provider
+type AuthProvider interface {
+	// UsersBySshKey returns ids of users the public key belongs to
+	UsersBySshKey(keyBase64, keyFingerprintSHA256 string) ([]string, error)
+	// Access checks any of the users may perform `verb` (git-upload-pack, git-receive-pack, ...) on the repo
+	Access(repo, verb string, users []string) (bool, error)
+	// AccessWithLogin authenticates user with password and returns user id along with access verdict
+	AccessWithLogin(org, repo, verb, username, password string) (bool, string, error)
+	// RepoOwner returns id of repo owner
+	RepoOwner(repo string) (string, error)
+}
 
-	granted := make([]UserAccess, 0, 1)
-	granted = append(granted, UserAccess{UserId: template.OwnerUserId, CanWrite: true})
+var authProvider AuthProvider = hubAuth{}
 
-	var teamErr error
-	for _, team := range template.Teams {
-		teamUsers, err := extapi.UsersByTeam(team.TeamId)
+func InitAuth() {
+	switch config.AuthProvider {
+	case "hub":
+		authProvider = hubAuth{}
+	case "static":
+		static, err := loadStaticAuth(config.AuthStaticFile)
 		if err != nil {
-			if teamErr == nil {
-				teamErr = err
-			}
-			continue
-		} else {
-			for _, userId := range teamUsers {
-				granted = append(granted, UserAccess{UserId: userId, CanWrite: team.CanWrite})
-			}
+			log.Fatalf("Unable to load static auth from `%s`: %v", config.AuthStaticFile, err)
 		}
+		authProvider = static
+	default:
+		log.Fatalf("Auth provider `%s` is not supported, try `hub` or `static`", config.AuthProvider)
 	}
-
-	writeRequested := verb == "git-receive-pack"
-
-	for _, userId := range users {
-		for _, grantedTo := range granted {
-			if userId == grantedTo.UserId && (!writeRequested || grantedTo.CanWrite) {
-				return true, teamErr
-			}
-		}
-	}
-
-	return false, teamErr
 }
 
-func AccessWithLogin(org, repo, verb, username, password string) (bool, error) {
-	orgId, err := orgId(repo)
-	if err != nil {
-		return false, err
-	}
-	templateId, err := TemplateId(repo)
-	if err != nil {
-		return false, err
-	}
-
-	user, err := extapi.Login(username, password)
-	if err != nil {
-		return false, fmt.Errorf("Unable to signin user `%s`: %v", username, err)
-	}
-
-	if strings.ToLower(org) != strings.ToLower(user.Organization) {
-		return false, fmt.Errorf("User org `%s` does not match repo org `%s`", user.Organization, org)
-	}
-
-	hubOrg, err := extapi.OrgById(orgId)
-	if err != nil {
-		return false, fmt.Errorf("Unable to fetch organization `%s` info: %v", orgId, err)
-	}
-	if !hubOrg.ShowSource {
-		return false, fmt.Errorf("Organization `%s` has no source code access", orgId)
-	}
-
-	template, err := extapi.TemplateById(templateId)
-	if err != nil {
-		return false, fmt.Errorf("Unable to fetch template `%s` info: %v", templateId, err)
-	}
-
-	if user.Uid == template.OwnerUserId {
-		return true, nil
-	}
+func UsersBySshKey(keyBase64, keyFingerprintSHA256 string) ([]string, error) {
+	return authProvider.UsersBySshKey(keyBase64, keyFingerprintSHA256)
+}
 
-	writeRequested := verb == "git-receive-pack"
+func Access(repo string, verb string, users []string) (bool, error) {
+	return authProvider.Access(repo, verb, users)
+}
 
-	for _, userTeam := range user.Groups {
-		for _, templateTeam := range template.Teams {
-			if userTeam == templateTeam.TeamName && (!writeRequested || templateTeam.CanWrite) {
-				return true, nil
-			}
-		}
-	}
+// AccessWithLogin returns user id along with access verdict
+func AccessWithLogin(org, repo, verb, username, password string) (bool, string, error) {
+	return authProvider.AccessWithLogin(org, repo, verb, username, password)
+}
 
-	return false, nil
+func repoOwner(repo string) (string, error) {
+	return authProvider.RepoOwner(repo)
 }
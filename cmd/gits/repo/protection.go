@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+const protectionFile = "gits-protection.json"
+
+type RefProtection struct {
+	Ref         string `json:"ref"` // ref name or glob pattern, ie. `master`, `refs/heads/release/*`
+	NoForcePush bool   `json:"noForcePush,omitempty"`
+	NoDeletion  bool   `json:"noDeletion,omitempty"`
+	OwnerOnly   bool   `json:"ownerOnly,omitempty"`
+}
+
+type Protection struct {
+	Refs []RefProtection `json:"refs"`
+}
+
+func GetProtection(repoId string) (*Protection, error) {
+	file := filepath.Join(config.RepoDir, repoId, protectionFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Protection{Refs: []RefProtection{}}, nil
+		}
+		return nil, fmt.Errorf("Unable to read `%s`: %v", file, err)
+	}
+	var protection Protection
+	err = json.Unmarshal(data, &protection)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal `%s`: %v", file, err)
+	}
+	return &protection, nil
+}
+
+func SetProtection(repoId string, protection *Protection) error {
+	for i, rule := range protection.Refs {
+		if rule.Ref == "" {
+			return fmt.Errorf("Protected ref at index %d is not set", i)
+		}
+		_, err := path.Match(rule.Ref, "")
+		if err != nil {
+			return fmt.Errorf("Protected ref `%s` is not supported: %v", rule.Ref, err)
+		}
+	}
+	data, err := json.MarshalIndent(protection, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(config.RepoDir, repoId, protectionFile)
+	temp := file + ".tmp"
+	err = ioutil.WriteFile(temp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to write `%s`: %v", temp, err)
+	}
+	return os.Rename(temp, file)
+}
+
+func (rule *RefProtection) matches(ref string) bool {
+	pattern := rule.Ref
+	if !strings.HasPrefix(pattern, "refs/") {
+		pattern = "refs/heads/" + pattern
+	}
+	match, _ := path.Match(pattern, ref)
+	return match
+}
+
+// checkRefUpdates returns refs the update is rejected for, with the reason; `users` is nil for API secret access;
+// `receive` is called once to store pushed objects before fast-forward is checked, it returns environment
+// for Git to find the objects
+func checkRefUpdates(repoId string, updates []refUpdate, users []string, receive func() ([]string, error)) (map[string]string, error) {
+	protection, err := GetProtection(repoId)
+	if err != nil {
+		return nil, err
+	}
+	rejected := make(map[string]string)
+	if len(protection.Refs) == 0 {
+		return rejected, nil
+	}
+
+	owner := ""
+	var env []string
+	for _, update := range updates {
+		for _, rule := range protection.Refs {
+			if !rule.matches(update.Ref) {
+				continue
+			}
+			if rule.OwnerOnly && users != nil {
+				if owner == "" {
+					owner, err = repoOwner(repoId)
+					if err != nil {
+						return nil, err
+					}
+				}
+				if !contains(users, owner) {
+					rejected[update.Ref] = "protected ref can only be updated by repository owner"
+					break
+				}
+			}
+			if rule.NoDeletion && update.isDelete() {
+				rejected[update.Ref] = "protected ref cannot be deleted"
+				break
+			}
+			if rule.NoForcePush && !update.isCreate() && !update.isDelete() {
+				if receive != nil {
+					env, err = receive()
+					if err != nil {
+						return nil, fmt.Errorf("Unable to receive pushed objects: %v", err)
+					}
+					receive = nil
+				}
+				fastForward, err := isFastForward(filepath.Join(config.RepoDir, repoId), env, update.Old, update.New)
+				if err != nil {
+					log.Printf("Unable to check `%s` update is fast-forward: %v", update.Ref, err)
+					rejected[update.Ref] = "unable to check update is fast-forward"
+				} else if !fastForward {
+					rejected[update.Ref] = "protected ref cannot be force-pushed"
+				}
+			}
+			break
+		}
+	}
+	return rejected, nil
+}
+
+// isFastForward checks `old` commit is an ancestor of `new`, `env` is extra environment for Git
+func isFastForward(dir string, env []string, old, new string) (bool, error) {
+	cmd := exec.Cmd{
+		Path: gitBinPath(),
+		Dir:  dir,
+		Args: []string{"git", "merge-base", "--is-ancestor", old, new},
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	gitDebug(&cmd)
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("git merge-base --is-ancestor %s %s: %v", old, new, err)
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,365 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Receive-pack request is a list of ref update commands followed by a packfile:
+   https://github.com/git/git/blob/master/Documentation/technical/pack-protocol.txt
+   The commands are inspected before the stream is handed over to Git. */
+
+const zeroId = "0000000000000000000000000000000000000000"
+
+var (
+	objectIdRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+	// pack with no objects is sent to Git in place of the pack already received
+	emptyPack = func() []byte {
+		header := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 0}
+		sum := sha1.Sum(header)
+		return append(header, sum[:]...)
+	}()
+)
+
+type refUpdate struct {
+	Old string
+	New string
+	Ref string
+}
+
+func (update refUpdate) isCreate() bool {
+	return update.Old == zeroId
+}
+
+func (update refUpdate) isDelete() bool {
+	return update.New == zeroId
+}
+
+// readRefUpdates reads receive-pack commands up to a flush and returns them with client capabilities
+// and the raw bytes consumed, so that the stream could be replayed to Git; push options that follow
+// the commands are consumed too, so that the rest of the stream is the packfile
+func readRefUpdates(in io.Reader) ([]refUpdate, []string, []byte, error) {
+	var consumed bytes.Buffer
+	scanner := pktline.NewScanner(io.TeeReader(in, &consumed))
+	updates := make([]refUpdate, 0)
+	var capabilities []string
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if len(updates) > 0 && hasCapability(capabilities, "push-options") {
+				err := skipPushOptions(scanner)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("Bad receive-pack push options: %v", err)
+				}
+			}
+			return updates, capabilities, consumed.Bytes(), nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if bytes.HasPrefix(line, []byte("shallow ")) {
+			continue
+		}
+		if i := bytes.IndexByte(line, 0); i >= 0 {
+			capabilities = strings.Fields(string(line[i+1:]))
+			line = line[:i]
+		}
+		parts := strings.Fields(string(line))
+		if len(parts) != 3 || !objectIdRegexp.MatchString(parts[0]) || !objectIdRegexp.MatchString(parts[1]) {
+			return nil, nil, nil, fmt.Errorf("Bad receive-pack command %q", line)
+		}
+		updates = append(updates, refUpdate{Old: parts[0], New: parts[1], Ref: parts[2]})
+	}
+	err := scanner.Err()
+	if err == nil && consumed.Len() > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return updates, capabilities, consumed.Bytes(), err
+}
+
+// skipPushOptions reads push options up to a flush
+func skipPushOptions(scanner *pktline.Scanner) error {
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			return nil
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// guardReceivePack locks the repository and checks ref updates against repository protection rules; it returns
+// the input stream to pass to Git, the updates, and unlock func; the stream is nil if there is nothing to do or
+// the push was rejected, in which case the rejection is already reported to the client and the repository is
+// not locked. The push is rejected as a whole if any of the updates is rejected.
+func guardReceivePack(repoId string, users []string, out io.Writer, in io.Reader) (io.Reader, []refUpdate, func(), error) {
+	updates, capabilities, consumed, err := readRefUpdates(in)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Unable to read receive-pack commands: %v", err)
+	}
+	if len(updates) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		rejected := make(map[string]string)
+		for _, update := range updates {
+			rejected[update.Ref] = "repository is locked by another operation, try again later"
+		}
+		rejectRefUpdates(repoId, users, out, in, updates, capabilities, rejected)
+		return nil, nil, nil, err
+	}
+
+	// fast-forward check needs the objects of the push, these are kept in quarantine until the push is accepted
+	dir := filepath.Join(config.RepoDir, repoId)
+	received := false
+	quarantine := ""
+	receive := func() ([]string, error) {
+		received = true
+		var err error
+		quarantine, err = receiveObjects(dir, in, updates)
+		if err != nil {
+			return nil, err
+		}
+		return quarantineEnv(dir, quarantine), nil
+	}
+	rejected, err := checkRefUpdates(repoId, updates, users, receive)
+	if received {
+		in = bytes.NewReader(emptyPack)
+	}
+	if quarantine != "" {
+		if err == nil && len(rejected) == 0 {
+			err = migrateObjects(quarantine, filepath.Join(dir, "objects"))
+		}
+		os.RemoveAll(quarantine)
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, nil, err
+	}
+	if len(rejected) > 0 {
+		unlock()
+		refs := rejectRefUpdates(repoId, users, out, in, updates, capabilities, rejected)
+		return nil, nil, nil, fmt.Errorf("Push to protected refs %v rejected", refs)
+	}
+	return io.MultiReader(bytes.NewReader(consumed), in), updates, unlock, nil
+}
+
+// rejectRefUpdates reports rejected refs to the client and returns them sorted
+func rejectRefUpdates(repoId string, users []string, out io.Writer, in io.Reader,
+	updates []refUpdate, capabilities []string, rejected map[string]string) []string {
+
+	// client won't read the report until it's done sending the pack
+	err := skipPack(in, updates)
+	if err != nil {
+		log.Printf("Unable to skip rejected push pack to `%s`: %v", repoId, err)
+	}
+	err = writeRefRejections(out, updates, capabilities, rejected)
+	if err != nil {
+		log.Printf("Unable to report rejected push to `%s`: %v", repoId, err)
+	}
+	refs := make([]string, 0, len(rejected))
+	for ref := range rejected {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	if config.Verbose {
+		log.Printf("Push to `%s` by %v rejected: %s", repoId, users, strings.Join(refs, ", "))
+	}
+	return refs
+}
+
+// skipPack reads packfile that follows the commands unless all of them are deletes; stream is
+// not read to EOF as SSH client keeps it open waiting for the report
+func skipPack(in io.Reader, updates []refUpdate) error {
+	return copyPack(in, ioutil.Discard, updates)
+}
+
+// copyPack is skipPack that copies the packfile to `out`
+func copyPack(in io.Reader, out io.Writer, updates []refUpdate) error {
+	needPack := false
+	for _, update := range updates {
+		if !update.isDelete() {
+			needPack = true
+		}
+	}
+	if !needPack {
+		return nil
+	}
+	scanner := packfile.NewScanner(io.TeeReader(in, out))
+	_, objects, err := scanner.Header()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < objects; i++ {
+		_, err = scanner.NextObjectHeader()
+		if err != nil {
+			return err
+		}
+		_, _, err = scanner.NextObject(ioutil.Discard)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = scanner.Checksum()
+	return err
+}
+
+// receiveObjects reads the packfile that follows the commands and stores it in a quarantine object directory
+// inside the repository, so that the objects could be inspected before refs are updated; returns the directory
+func receiveObjects(dir string, in io.Reader, updates []refUpdate) (string, error) {
+	file, err := ioutil.TempFile("", "gits-pack-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = copyPack(in, file, updates)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read pack: %v", err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	quarantine, err := ioutil.TempDir(filepath.Join(dir, "objects"), "incoming-gits-")
+	if err != nil {
+		return "", err
+	}
+	err = os.Mkdir(filepath.Join(quarantine, "pack"), dirMode)
+	if err != nil {
+		os.RemoveAll(quarantine)
+		return "", err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Cmd{
+		Path:  gitBinPath(),
+		Dir:   dir,
+		Args:  []string{"git", "index-pack", "--stdin", "--fix-thin"},
+		Env:   append(os.Environ(), quarantineEnv(dir, quarantine)...),
+		Stdin: file,
+	}
+	gitDebug2(&cmd, &stdout)
+	err = cmd.Run()
+	if err != nil {
+		os.RemoveAll(quarantine)
+		return "", fmt.Errorf("git index-pack: %v", err)
+	}
+	return quarantine, nil
+}
+
+// quarantineEnv sets up Git to write objects to quarantine directory and read from both
+func quarantineEnv(dir, quarantine string) []string {
+	return []string{
+		"GIT_OBJECT_DIRECTORY=" + quarantine,
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + filepath.Join(dir, "objects"),
+	}
+}
+
+// migrateObjects moves packs from quarantine to repository object directory,
+// index is moved last so that Git won't see the pack before it's in place
+func migrateObjects(quarantine, objects string) error {
+	packs, err := filepath.Glob(filepath.Join(quarantine, "pack", "pack-*"))
+	if err != nil {
+		return err
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return strings.HasSuffix(packs[j], ".idx") && !strings.HasSuffix(packs[i], ".idx")
+	})
+	for _, pack := range packs {
+		err = os.Rename(pack, filepath.Join(objects, "pack", filepath.Base(pack)))
+		if err != nil {
+			return fmt.Errorf("Unable to move received objects to repository: %v", err)
+		}
+	}
+	return nil
+}
+
+func hasCapability(capabilities []string, wanted ...string) bool {
+	for _, capability := range capabilities {
+		for _, w := range wanted {
+			if capability == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeRefRejections writes report-status with `ng` for every ref, the push is rejected as a whole
+func writeRefRejections(out io.Writer, updates []refUpdate, capabilities []string, rejected map[string]string) error {
+	if !hasCapability(capabilities, "report-status", "report-status-v2") {
+		return nil
+	}
+	var report bytes.Buffer
+	enc := pktline.NewEncoder(&report)
+	err := enc.Encodef("unpack ok\n")
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		reason, exist := rejected[update.Ref]
+		if !exist {
+			reason = "push contains updates to protected refs"
+		}
+		err = enc.Encodef("ng %s %s\n", update.Ref, reason)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	maxBand := 0
+	if hasCapability(capabilities, "side-band-64k") {
+		maxBand = pktline.MaxPayloadSize - 1
+	} else if hasCapability(capabilities, "side-band") {
+		maxBand = 999 - 4 - 1
+	}
+	if maxBand == 0 {
+		_, err = out.Write(report.Bytes())
+		return err
+	}
+	enc = pktline.NewEncoder(out)
+	for _, update := range updates {
+		if reason, exist := rejected[update.Ref]; exist {
+			err = enc.Encode(append([]byte{2}, fmt.Sprintf("error: %s: %s\n", update.Ref, reason)...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	data := report.Bytes()
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxBand {
+			chunk = chunk[:maxBand]
+		}
+		err = enc.Encode(append([]byte{1}, chunk...))
+		if err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+	}
+	return enc.Flush()
+}
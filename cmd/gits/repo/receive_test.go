@@ -0,0 +1,326 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+)
+
+// pushRequest encodes receive-pack commands followed by a thin pack of `revs` built in `work` repo
+func pushRequest(t *testing.T, work string, capabilities string, updates []refUpdate, revs ...string) []byte {
+	t.Helper()
+	return pushRequestWithOptions(t, work, capabilities, updates, nil, revs...)
+}
+
+// pushRequestWithOptions is pushRequest with push options sent after the commands
+func pushRequestWithOptions(t *testing.T, work string, capabilities string, updates []refUpdate,
+	options []string, revs ...string) []byte {
+
+	t.Helper()
+	var req bytes.Buffer
+	enc := pktline.NewEncoder(&req)
+	for i, update := range updates {
+		line := fmt.Sprintf("%s %s %s", update.Old, update.New, update.Ref)
+		if i == 0 {
+			line += "\x00" + capabilities
+		}
+		if err := enc.Encodef("%s\n", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc.Flush()
+	if options != nil {
+		for _, option := range options {
+			enc.Encodef("%s\n", option)
+		}
+		enc.Flush()
+	}
+	if len(revs) > 0 {
+		cmd := exec.Command("git", "pack-objects", "--revs", "--thin", "--stdout", "-q")
+		cmd.Dir = work
+		cmd.Stdin = strings.NewReader(strings.Join(revs, "\n") + "\n")
+		pack, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git pack-objects: %v", err)
+		}
+		req.Write(pack)
+	}
+	return req.Bytes()
+}
+
+func TestReadRefUpdates(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	var req bytes.Buffer
+	enc := pktline.NewEncoder(&req)
+	enc.Encodef("%s %s refs/heads/master\x00report-status side-band-64k\n", a, b)
+	enc.Encodef("%s %s refs/heads/old\n", a, zeroId)
+	enc.Flush()
+	commands := len(req.Bytes())
+	req.WriteString("PACK")
+
+	updates, capabilities, consumed, err := readRefUpdates(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[0] != (refUpdate{a, b, "refs/heads/master"}) || !updates[1].isDelete() {
+		t.Errorf("Unexpected updates %v", updates)
+	}
+	if !hasCapability(capabilities, "report-status") || !hasCapability(capabilities, "side-band-64k") {
+		t.Errorf("Unexpected capabilities %v", capabilities)
+	}
+	if len(consumed) != commands {
+		t.Errorf("Expected %d bytes of commands consumed, got %d", commands, len(consumed))
+	}
+
+	// push options are consumed with the commands
+	req.Reset()
+	enc.Encodef("%s %s refs/heads/master\x00report-status push-options\n", a, b)
+	enc.Flush()
+	enc.Encodef("ci.skip\n")
+	enc.Flush()
+	commands = len(req.Bytes())
+	req.WriteString("PACK")
+	_, _, consumed, err = readRefUpdates(&req)
+	if err != nil || len(consumed) != commands {
+		t.Errorf("Expected %d bytes of commands and push options consumed, got %d: %v", commands, len(consumed), err)
+	}
+
+	for _, bad := range []string{
+		fmt.Sprintf("%s --output=x refs/heads/master\n", a),
+		fmt.Sprintf("%s %s\n", a, b),
+	} {
+		req.Reset()
+		enc.Encodef("%s", bad)
+		enc.Flush()
+		_, _, _, err = readRefUpdates(&req)
+		if err == nil {
+			t.Errorf("Expected command %q to be rejected", bad)
+		}
+	}
+}
+
+func TestCheckRefUpdates(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a")
+	b := commitFile(t, work, "README", "b")
+	git(t, work, "checkout", "-q", "-b", "feature", a)
+	c := commitFile(t, work, "README", "c")
+	bareRepo(t, "check/refs-1", work)
+
+	err := SetProtection("check/refs-1", &Protection{Refs: []RefProtection{
+		{Ref: "master", NoForcePush: true},
+		{Ref: "release/*", NoDeletion: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receives := 0
+	receive := func() ([]string, error) {
+		receives++
+		return nil, nil
+	}
+	updates := []refUpdate{
+		{a, b, "refs/heads/master"},
+		{b, c, "refs/heads/feature"},
+		{zeroId, a, "refs/heads/release/1"},
+	}
+	rejected, err := checkRefUpdates("check/refs-1", updates, nil, receive)
+	if err != nil || len(rejected) != 0 {
+		t.Errorf("Expected fast-forward of protected and force push of unprotected ref, got %v: %v", rejected, err)
+	}
+
+	updates = []refUpdate{
+		{b, c, "refs/heads/master"},
+		{b, a, "refs/heads/feature"},
+		{a, zeroId, "refs/heads/release/1"},
+		{a, zeroId, "refs/heads/master"},
+	}
+	rejected, err = checkRefUpdates("check/refs-1", updates, nil, receive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 2 || rejected["refs/heads/master"] != "protected ref cannot be force-pushed" ||
+		rejected["refs/heads/release/1"] != "protected ref cannot be deleted" {
+		t.Errorf("Unexpected rejections %v", rejected)
+	}
+	if receives != 2 {
+		t.Errorf("Expected objects received once per check, got %d", receives)
+	}
+}
+
+func TestWriteRefRejections(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	updates := []refUpdate{{a, zeroId, "refs/heads/master"}, {zeroId, a, "refs/heads/feature"}}
+	rejected := map[string]string{"refs/heads/master": "protected ref cannot be deleted"}
+
+	var out bytes.Buffer
+	err := writeRefRejections(&out, updates, []string{"report-status"}, rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := func(line string) string {
+		return fmt.Sprintf("%04x%s", 4+len(line), line)
+	}
+	expected := pkt("unpack ok\n") +
+		pkt("ng refs/heads/master protected ref cannot be deleted\n") +
+		pkt("ng refs/heads/feature push contains updates to protected refs\n") +
+		"0000"
+	if out.String() != expected {
+		t.Errorf("Unexpected report:\n%q\nwant:\n%q", out.String(), expected)
+	}
+
+	out.Reset()
+	err = writeRefRejections(&out, updates, []string{"report-status", "side-band-64k"}, rejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := pktline.NewScanner(&out)
+	var progress, report bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			break
+		}
+		switch line[0] {
+		case 1:
+			report.Write(line[1:])
+		case 2:
+			progress.Write(line[1:])
+		}
+	}
+	if progress.String() != "error: refs/heads/master: protected ref cannot be deleted\n" {
+		t.Errorf("Unexpected side-band progress %q", progress.String())
+	}
+	if report.String() != expected {
+		t.Errorf("Unexpected side-band report %q", report.String())
+	}
+
+	out.Reset()
+	err = writeRefRejections(&out, updates, nil, rejected)
+	if err != nil || out.Len() != 0 {
+		t.Errorf("Expected no report without report-status capability, got %q: %v", out.String(), err)
+	}
+}
+
+func TestSkipPack(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a")
+	b := commitFile(t, work, "README", "b")
+
+	req := pushRequest(t, work, "report-status", []refUpdate{{a, b, "refs/heads/master"}}, b, "^"+a)
+	in := bytes.NewReader(req)
+	_, _, _, err := readRefUpdates(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = skipPack(in, []refUpdate{{a, b, "refs/heads/master"}})
+	if err != nil {
+		t.Errorf("Unable to skip pack: %v", err)
+	}
+
+	// delete-only push has no pack, the stream must not be read
+	in = bytes.NewReader([]byte("more"))
+	err = skipPack(in, []refUpdate{{a, zeroId, "refs/heads/master"}})
+	if rest, _ := ioutil.ReadAll(in); err != nil || string(rest) != "more" {
+		t.Errorf("Expected stream intact on delete-only push, got %q: %v", rest, err)
+	}
+
+	err = skipPack(bytes.NewReader(emptyPack), []refUpdate{{a, b, "refs/heads/master"}})
+	if err != nil {
+		t.Errorf("Unable to skip empty pack: %v", err)
+	}
+}
+
+func TestReceivePackProtection(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a")
+	b := commitFile(t, work, "README", "b")
+	git(t, work, "checkout", "-q", "-b", "feature", a)
+	c := commitFile(t, work, "README", "c")
+	dir := bareRepo(t, "check/push-1", work)
+	err := SetProtection("check/push-1", &Protection{Refs: []RefProtection{{Ref: "master", NoForcePush: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// master: b -> d fast-forward, feature: c -> e forced
+	git(t, work, "checkout", "-q", "master")
+	d := commitFile(t, work, "README", "d")
+	git(t, work, "checkout", "-q", "-b", "other", a)
+	e := commitFile(t, work, "README", "e")
+
+	updates := []refUpdate{{b, d, "refs/heads/master"}, {c, e, "refs/heads/feature"}}
+	req := pushRequest(t, work, "report-status", updates, d, e, "^"+b, "^"+c)
+	var out bytes.Buffer
+	err = pack("check/push-1", "git-receive-pack", "", nil, "http", &out, bytes.NewReader(req))
+	if err != nil {
+		t.Fatalf("Mixed push failed: %v: %s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "ok refs/heads/master") || !strings.Contains(out.String(), "ok refs/heads/feature") {
+		t.Errorf("Unexpected report %q", out.String())
+	}
+	if master := git(t, dir, "rev-parse", "master"); master != d {
+		t.Errorf("Expected master at %s, got %s", d, master)
+	}
+	if feature := git(t, dir, "rev-parse", "feature"); feature != e {
+		t.Errorf("Expected feature at %s, got %s", e, feature)
+	}
+
+	// master: d -> f is not fast-forward
+	git(t, work, "checkout", "-q", "-b", "forced", a)
+	f := commitFile(t, work, "README", "f")
+	updates = []refUpdate{{d, f, "refs/heads/master"}, {e, d, "refs/heads/feature"}}
+	req = pushRequest(t, work, "report-status", updates, f, "^"+d)
+	out.Reset()
+	err = pack("check/push-1", "git-receive-pack", "", nil, "http", &out, bytes.NewReader(req))
+	if err == nil {
+		t.Error("Expected force push to protected ref to fail")
+	}
+	if !strings.Contains(out.String(), "ng refs/heads/master protected ref cannot be force-pushed") ||
+		!strings.Contains(out.String(), "ng refs/heads/feature push contains updates to protected refs") {
+		t.Errorf("Unexpected report %q", out.String())
+	}
+	if master := git(t, dir, "rev-parse", "master"); master != d {
+		t.Errorf("Expected master to stay at %s, got %s", d, master)
+	}
+	// objects of rejected push are not stored
+	if objects := git(t, dir, "cat-file", "--batch-check", "--batch-all-objects"); strings.Contains(objects, f) {
+		t.Errorf("Objects of rejected push are stored in repository")
+	}
+	if incoming, _ := filepath.Glob(filepath.Join(dir, "objects", "incoming-*")); len(incoming) > 0 {
+		t.Errorf("Quarantine is not removed: %v", incoming)
+	}
+}
+
+func TestReceivePackPushOptions(t *testing.T) {
+	work := workRepo(t)
+	a := commitFile(t, work, "README", "a")
+	dir := bareRepo(t, "check/push-2", work)
+	git(t, dir, "config", "receive.advertisePushOptions", "true")
+	err := SetProtection("check/push-2", &Protection{Refs: []RefProtection{{Ref: "master", NoForcePush: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := commitFile(t, work, "README", "b")
+
+	updates := []refUpdate{{a, b, "refs/heads/master"}}
+	req := pushRequestWithOptions(t, work, "report-status push-options", updates, []string{"ci.skip"}, b, "^"+a)
+	var out bytes.Buffer
+	err = pack("check/push-2", "git-receive-pack", "", nil, "http", &out, bytes.NewReader(req))
+	if err != nil || !strings.Contains(out.String(), "ok refs/heads/master") {
+		t.Fatalf("Push with options failed: %v: %s", err, out.String())
+	}
+	if master := git(t, dir, "rev-parse", "master"); master != b {
+		t.Errorf("Expected master at %s, got %s", b, master)
+	}
+	if objects := git(t, dir, "cat-file", "--batch-check", "--batch-all-objects"); !strings.Contains(objects, b) {
+		t.Errorf("Objects of accepted push are not moved to repository")
+	}
+}
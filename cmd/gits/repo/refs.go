@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
 )
 
 func Exist(repoId string) bool {
@@ -19,12 +20,19 @@ func Exist(repoId string) bool {
 // `service` parameter is validated by HTTP handling layer and
 // is verified to be one of git-upload-pack, git-receive-pack
 
-func RefsInfo(repoId string, service string, out io.Writer) error {
+// `protocol` is Git wire protocol parameters sent by client, ie. `version=2`, passed to
+// Git via GIT_PROTOCOL. Native Git server ignores it and always speaks protocol v0.
+
+func RefsInfo(repoId string, service string, protocol string, out io.Writer) error {
 	dir := filepath.Join(config.RepoDir, repoId)
+	if config.NativeGit {
+		return nativeRefsInfo(dir, service, out)
+	}
 	cmd := exec.Cmd{
 		Path:   gitSubCommandBinPath(service),
 		Dir:    dir,
 		Args:   []string{service, "--stateless-rpc", "--advertise-refs", "."},
+		Env:    gitProtocolEnv(protocol),
 		Stdout: out,
 	}
 	if config.Trace {
@@ -34,12 +42,36 @@ func RefsInfo(repoId string, service string, out io.Writer) error {
 	return cmd.Run()
 }
 
-func Pack(repoId string, service string, out io.Writer, in io.Reader) error {
+// `users` are checked against protected refs rules on push, nil means API secret was presented
+
+func Pack(repoId string, service string, protocol string, users []string, out io.Writer, in io.Reader) error {
+	return pack(repoId, service, protocol, users, webhooks.TransportHttp, out, in)
+}
+
+func pack(repoId string, service string, protocol string, users []string, transport string,
+	out io.Writer, in io.Reader) error {
+
 	dir := filepath.Join(config.RepoDir, repoId)
+	if service == "git-receive-pack" {
+		var updates []refUpdate
+		var unlock func()
+		var err error
+		in, updates, unlock, err = guardReceivePack(repoId, users, out, in)
+		if in == nil || err != nil {
+			return err
+		}
+		defer unlock()
+		// some refs might be updated even if Git reports an error
+		defer notifyRefUpdates(repoId, updates, users, transport)
+	}
+	if config.NativeGit {
+		return nativePack(dir, service, out, in, true)
+	}
 	cmd := exec.Cmd{
 		Path:   gitSubCommandBinPath(service),
 		Dir:    dir,
 		Args:   []string{service, "--stateless-rpc", "."},
+		Env:    gitProtocolEnv(protocol),
 		Stdout: out,
 		Stdin:  in,
 	}
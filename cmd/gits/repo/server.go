@@ -9,11 +9,17 @@ import (
 	"strings"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
 )
 
 /* https://github.com/go-gitea/gitea/blob/HEAD/cmd/serv.go */
 
-func GitServer(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer, users []string) (*exec.Cmd, error) {
+// GitProcess is either exec-ed git-*-pack binary or native in-process Git server
+type GitProcess interface {
+	Wait() error
+}
+
+func GitServer(command string, protocol string, stdin io.Reader, stdout io.Writer, stderr io.Writer, users []string) (GitProcess, error) {
 	if config.Debug {
 		log.Printf("Git command requested: %q", command)
 	}
@@ -34,23 +40,45 @@ func GitServer(command string, stdin io.Reader, stdout io.Writer, stderr io.Writ
 	if config.Debug {
 		log.Printf("Git command parsed: %s %s", verb, repo)
 	}
+	if !Exist(repo) {
+		if moved, ok := Redirected(repo); ok {
+			// follow redirect only for users with access to the old repo id
+			err := checkAccess(repo, verb, users)
+			if err != nil {
+				return nil, err
+			}
+			if config.Verbose {
+				log.Printf("Repo `%s` moved, redirecting to `%s`", repo, moved)
+			}
+			repo = moved
+		}
+	}
 
-	hasAccess, err := Access(repo, verb, users)
+	err := checkAccess(repo, verb, users)
 	if err != nil {
-		log.Printf("Checking `%s` repo permissions for %v: %v", repo, users, err)
-	}
-	if !hasAccess {
-		err := fmt.Errorf("%v have no access to `%s`", users, repo)
-		if config.Verbose {
-			log.Printf("%v", err)
-		}
 		return nil, err
 	}
-	if config.Debug {
-		log.Printf("%v have access to `%s`", users, repo)
+
+	if verb == "git-receive-pack" {
+		// serve push as stateless request to inspect ref updates before Git sees them
+		return startAsync(func() error {
+			err := RefsInfo(repo, verb, protocol, stdout)
+			if err != nil {
+				return err
+			}
+			err = pack(repo, verb, protocol, users, webhooks.TransportSsh, stdout, stdin)
+			if err != nil {
+				fmt.Fprintf(stderr, "error: %v\n", err)
+			}
+			return err
+		}), nil
 	}
 
 	repoPath := filepath.Join(config.RepoDir, repo)
+	if config.NativeGit && verb != "git-upload-archive" {
+		return startNative(repoPath, verb, stdout, stdin), nil
+	}
+
 	gitBinPath, err := exec.LookPath(verb)
 	if err != nil {
 		if config.Trace {
@@ -66,13 +94,18 @@ func GitServer(command string, stdin io.Reader, stdout io.Writer, stderr io.Writ
 		Stderr: stderr,
 		Dir:    repoPath,
 		Args:   []string{verb, "."},
+		Env:    gitProtocolEnv(protocol),
 	}
 	// a workaround for bizarre Wait() lockup
 	inputPipe, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, err
 	}
-	go io.Copy(inputPipe, stdin)
+	go func() {
+		io.Copy(inputPipe, stdin)
+		// protocol v2 server loops waiting for next command until EOF
+		inputPipe.Close()
+	}()
 	if config.Trace {
 		log.Printf("Starting Git:\n\t%+v", cmd)
 	}
@@ -83,6 +116,24 @@ func GitServer(command string, stdin io.Reader, stdout io.Writer, stderr io.Writ
 	return &cmd, nil
 }
 
+func checkAccess(repo, verb string, users []string) error {
+	hasAccess, err := Access(repo, verb, users)
+	if err != nil {
+		log.Printf("Checking `%s` repo permissions for %v: %v", repo, users, err)
+	}
+	if !hasAccess {
+		err := fmt.Errorf("%v have no access to `%s`", users, repo)
+		if config.Verbose {
+			log.Printf("%v", err)
+		}
+		return err
+	}
+	if config.Debug {
+		log.Printf("%v have access to `%s`", users, repo)
+	}
+	return nil
+}
+
 var allowedVerbs = []string{"git-receive-pack", "git-upload-archive", "git-upload-pack"}
 
 func allowedVerb(verb string) bool {
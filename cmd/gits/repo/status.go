@@ -118,3 +118,57 @@ func commitByRef(dir, ref string) (string, string, error) {
 
 	return commits[0], refs[0], nil
 }
+
+var refTypes = map[string][]string{
+	"":      {"refs/heads", "refs/tags"},
+	"heads": {"refs/heads"},
+	"tags":  {"refs/tags"},
+}
+
+// Refs returns branches and/or tags with tip commit status, `prefix` matches either full or short ref name
+func Refs(repoId, refType, prefix string) ([]RepoStatus, error) {
+	patterns, exist := refTypes[refType]
+	if !exist {
+		return nil, fmt.Errorf("Ref type `%s` is not supported", refType)
+	}
+	dir := filepath.Join(config.RepoDir, repoId)
+
+	// annotated tag fields are prefixed with * to dereference the tag to commit
+	fields := []string{"%(refname)", "%(objecttype)", "%(objectname)",
+		"%(authordate:iso-strict)", "%(committername) %(committeremail)", "%(subject)",
+		"%(*objecttype)", "%(*objectname)",
+		"%(*authordate:iso-strict)", "%(*committername) %(*committeremail)", "%(*subject)"}
+	args := append([]string{"for-each-ref", "--format=" + strings.Join(fields, "%00")}, patterns...)
+	output, err := gitOutput(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]RepoStatus, 0)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Split(line, "\x00")
+		if len(parts) != len(fields) {
+			continue
+		}
+		ref := parts[0]
+		if prefix != "" && !strings.HasPrefix(ref, prefix) {
+			short := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+			if !strings.HasPrefix(short, prefix) {
+				continue
+			}
+		}
+		if parts[6] != "" {
+			parts = append(parts[:1], parts[6:]...)
+		}
+		status := RepoStatus{Ref: ref}
+		// tag may point to a tree or blob
+		if parts[1] == "commit" {
+			status.Commit = parts[2]
+			status.Date = parts[3]
+			status.Author = parts[4]
+			status.Subject = parts[5]
+		}
+		refs = append(refs, status)
+	}
+	return refs, nil
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
 	"github.com/agilestacks/git-service/cmd/gits/util"
+	"github.com/agilestacks/git-service/cmd/gits/webhooks"
 )
 
 type AddSubtree struct {
@@ -31,13 +32,17 @@ type RemoteWithRef struct {
 }
 
 // TODO add subtree to an empty branch
-func AddSubtrees(repoId, branch string, subtrees []AddSubtree) error {
+func AddSubtrees(repoId, branch string, subtrees []AddSubtree, identity *CommitIdentity) error {
 	dir := filepath.Join(config.RepoDir, repoId)
 	if branch == "" {
 		branch = "master"
 	}
 
 	// validate, set defaults
+	err := identity.Validate()
+	if err != nil {
+		return err
+	}
 	for i, subtree := range subtrees {
 		if subtree.Prefix == "" || subtree.Remote == "" ||
 			!(strings.HasPrefix(subtree.Remote, "http:") || strings.HasPrefix(subtree.Remote, "https:") ||
@@ -49,6 +54,12 @@ func AddSubtrees(repoId, branch string, subtrees []AddSubtree) error {
 		}
 	}
 
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// temp dir for clone
 	clone, err := ioutil.TempDir("", "gits-")
 	if err != nil {
@@ -56,6 +67,9 @@ func AddSubtrees(repoId, branch string, subtrees []AddSubtree) error {
 	}
 	defer deleteDir(clone)
 
+	before := watchRefs(repoId)
+	defer notifyRefChanges(repoId, before, nil, webhooks.TransportApi)
+
 	gitBin := gitBinPath()
 	// clone
 	cmd := exec.Cmd{
@@ -180,7 +194,7 @@ func AddSubtrees(repoId, branch string, subtrees []AddSubtree) error {
 		if subtree.Squash {
 			args = append(args, "--squash")
 		}
-		cmd = exec.Cmd{Path: gitBin, Dir: clone, Args: args}
+		cmd = exec.Cmd{Path: gitBin, Dir: clone, Args: args, Env: append(os.Environ(), identity.env()...)}
 		gitDebug(&cmd)
 		err = cmd.Run()
 		if err != nil {
@@ -241,9 +255,10 @@ func AddSubtrees(repoId, branch string, subtrees []AddSubtree) error {
 	return nil
 }
 
+// maskAuth hides credentials of remote URL to put it in logs, errors, and commit messages
 func maskAuth(maybeUrl string) string {
 	remote, err := url.Parse(maybeUrl)
-	if err != nil {
+	if err != nil || remote.User == nil {
 		return maybeUrl
 	}
 	remote.User = url.UserPassword("masked", "")
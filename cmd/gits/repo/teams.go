@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/extapi"
+)
+
+var errTeamTimeout = errors.New("Timeout fetching team members")
+
+type TeamFailure struct {
+	TeamId   string
+	TeamName string
+	Err      error
+}
+
+// TeamError lists teams which members could not be fetched
+type TeamError struct {
+	Failures []TeamFailure
+}
+
+func (e *TeamError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		failures = append(failures, fmt.Sprintf("team `%s`: %v", failure.TeamId, failure.Err))
+	}
+	return fmt.Sprintf("Unable to fetch %d team(s) members: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
+type teamMembers struct {
+	index   int
+	members []string
+	err     error
+}
+
+// teamsGrant fetches teams members concurrently and returns as soon as any of the users is found in a team,
+// failures and teams not fetched in -team_lookup_timeout are returned as *TeamError if no team grants access
+func teamsGrant(teams []extapi.TeamAccess, users []string) (bool, error) {
+	if len(teams) == 0 {
+		return false, nil
+	}
+	workers := config.TeamLookupConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(teams) {
+		workers = len(teams)
+	}
+
+	jobs := make(chan int)
+	// buffered so that workers never block after we're done
+	results := make(chan teamMembers, len(teams))
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(jobs)
+		for i := range teams {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				members, err := extapi.UsersByTeam(teams[i].TeamId)
+				results <- teamMembers{index: i, members: members, err: err}
+			}
+		}()
+	}
+
+	deadline := time.NewTimer(config.TeamLookupTimeout)
+	defer deadline.Stop()
+
+	fetched := make([]bool, len(teams))
+	errs := make([]error, len(teams))
+wait:
+	for received := 0; received < len(teams); received++ {
+		select {
+		case result := <-results:
+			fetched[result.index] = true
+			if result.err != nil {
+				errs[result.index] = result.err
+				continue
+			}
+			for _, userId := range users {
+				if contains(result.members, userId) {
+					return true, nil
+				}
+			}
+		case <-deadline.C:
+			for i := range teams {
+				if !fetched[i] {
+					errs[i] = errTeamTimeout
+				}
+			}
+			break wait
+		}
+	}
+
+	var failures []TeamFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, TeamFailure{TeamId: teams[i].TeamId, TeamName: teams[i].TeamName, Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return false, &TeamError{Failures: failures}
+	}
+	return false, nil
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+	"github.com/agilestacks/git-service/cmd/gits/extapi"
+)
+
+func TestTeamsGrant(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/teams/fast":
+			w.Write([]byte(`{"members": [{"id": "alice", "status": "ACTIVE"}]}`))
+		case "/teams/slow":
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte(`{"members": [{"id": "bob", "status": "ACTIVE"}]}`))
+		case "/teams/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	config.AuthApiEndpoint = server.URL
+	config.ExtApiCacheTtl = 0
+	config.TeamLookupConcurrency = 4
+	config.TeamLookupTimeout = 100 * time.Millisecond
+
+	teams := []extapi.TeamAccess{{TeamId: "slow"}, {TeamId: "broken"}, {TeamId: "fast"}}
+
+	start := time.Now()
+	granted, err := teamsGrant(teams, []string{"alice"})
+	if !granted || err != nil {
+		t.Errorf("Expected access via team `fast`, got %v: %v", granted, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected short-circuit on granting team, took %v", elapsed)
+	}
+
+	granted, err = teamsGrant(teams, []string{"bob"})
+	if granted {
+		t.Error("Expected no access when team `slow` exceeds deadline")
+	}
+	teamErr, ok := err.(*TeamError)
+	if !ok || len(teamErr.Failures) != 2 ||
+		teamErr.Failures[0].TeamId != "slow" || teamErr.Failures[0].Err != errTeamTimeout ||
+		teamErr.Failures[1].TeamId != "broken" {
+		t.Errorf("Unexpected team failures: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Deleted repositories are moved to <repo_dir>/_trash/<org>/<repo>.<unix time> and purged after
+   -trash_retention. With zero retention repositories are deleted immediately. */
+
+const (
+	trashDir           = "_trash"
+	trashPurgeInterval = time.Hour
+)
+
+type TrashedRepo struct {
+	Id      string    `json:"id"`
+	Deleted time.Time `json:"deleted"`
+	Expires time.Time `json:"expires"`
+	Size    int64     `json:"size"`
+	dir     string
+}
+
+func trash(repoId, dir string) error {
+	deleted := time.Now().Unix()
+	var trashed string
+	for {
+		trashed = filepath.Join(config.RepoDir, trashDir, fmt.Sprintf("%s.%d", repoId, deleted))
+		if _, err := os.Stat(trashed); os.IsNotExist(err) {
+			break
+		}
+		deleted++
+	}
+	err := os.MkdirAll(filepath.Dir(trashed), dirMode)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(dir, trashed)
+	if err != nil {
+		return fmt.Errorf("Unable to move `%s` to trash: %v", repoId, err)
+	}
+	// remove organization directory if empty
+	os.Remove(filepath.Dir(dir))
+	return nil
+}
+
+// Trash returns trashed repositories of organization, or all if `org` is empty, most recent first
+func Trash(org string) ([]TrashedRepo, error) {
+	base := filepath.Join(config.RepoDir, trashDir)
+	orgs := []string{org}
+	if org == "" {
+		var err error
+		orgs, err = subDirs(base)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return []TrashedRepo{}, nil
+			}
+			return nil, fmt.Errorf("Unable to list `%s`: %v", base, err)
+		}
+	}
+	repos := make([]TrashedRepo, 0)
+	for _, org := range orgs {
+		orgDir := filepath.Join(base, org)
+		dirs, err := subDirs(orgDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("Unable to list `%s`: %v", orgDir, err)
+		}
+		for _, name := range dirs {
+			dot := strings.LastIndex(name, ".")
+			if dot <= 0 {
+				continue
+			}
+			deleted, err := strconv.ParseInt(name[dot+1:], 10, 64)
+			if err != nil {
+				continue
+			}
+			dir := filepath.Join(orgDir, name)
+			size, err := dirSize(dir)
+			if err != nil {
+				log.Printf("Unable to calculate `%s` size: %v", dir, err)
+			}
+			deletedAt := time.Unix(deleted, 0).UTC()
+			repos = append(repos, TrashedRepo{
+				Id:      org + "/" + name[:dot],
+				Deleted: deletedAt,
+				Expires: deletedAt.Add(config.TrashRetention),
+				Size:    size,
+				dir:     dir,
+			})
+		}
+	}
+	sort.SliceStable(repos, func(i, j int) bool { return repos[i].Deleted.After(repos[j].Deleted) })
+	return repos, nil
+}
+
+// Restore moves repository back from trash, the most recently deleted one unless `deleted` unix time is set
+func Restore(repoId string, deleted int64) (*TrashedRepo, error) {
+	err := checkRepoId(repoId)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockRepo(repoId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := filepath.Join(config.RepoDir, repoId)
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("Repository `%s` already exist", repoId)
+	}
+	trashed, err := Trash(strings.Split(repoId, "/")[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range trashed {
+		if repo.Id != repoId || (deleted != 0 && repo.Deleted.Unix() != deleted) {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(dir), dirMode)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Rename(repo.dir, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to restore `%s` from trash: %v", repoId, err)
+		}
+		os.Remove(filepath.Dir(repo.dir))
+		if config.Verbose {
+			log.Printf("Repo `%s` deleted at %v restored", repoId, repo.Deleted)
+		}
+		return &repo, nil
+	}
+	return nil, fmt.Errorf("Repository `%s` not found in trash", repoId)
+}
+
+// PurgeTrash permanently deletes repositories trashed longer than -trash_retention ago
+func PurgeTrash() {
+	trashed, err := Trash("")
+	if err != nil {
+		log.Printf("Unable to purge trash: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, repo := range trashed {
+		if now.After(repo.Expires) {
+			purgeTrashed(repo)
+		}
+	}
+}
+
+// purgeTrashed deletes trashed repository under repository lock, so that it's not restored at the same time
+func purgeTrashed(repo TrashedRepo) {
+	unlock, err := lockRepo(repo.Id)
+	if err != nil {
+		log.Printf("Unable to purge `%s` deleted at %v from trash: %v", repo.Id, repo.Deleted, err)
+		return
+	}
+	defer unlock()
+
+	if _, err := os.Stat(repo.dir); err != nil {
+		return
+	}
+	if deleteDir(repo.dir) == nil {
+		os.Remove(filepath.Dir(repo.dir))
+		if config.Verbose {
+			log.Printf("Repo `%s` deleted at %v purged from trash", repo.Id, repo.Deleted)
+		}
+	}
+}
+
+// TrashPurger runs PurgeTrash periodically
+func TrashPurger() {
+	if config.TrashRetention <= 0 {
+		return
+	}
+	interval := trashPurgeInterval
+	if config.TrashRetention < interval {
+		interval = config.TrashRetention
+	}
+	go func() {
+		for {
+			PurgeTrash()
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func withTrash(t *testing.T) {
+	retention := config.TrashRetention
+	config.TrashRetention = time.Hour
+	t.Cleanup(func() { config.TrashRetention = retention })
+}
+
+// createMarked creates repository with `marker` file to tell copies apart
+func createMarked(t *testing.T, repoId, marker string) {
+	t.Helper()
+	err := Create(repoId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(config.RepoDir, repoId, "marker"), []byte(marker), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func marker(t *testing.T, repoId string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(config.RepoDir, repoId, "marker"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTrashRestore(t *testing.T) {
+	withTrash(t)
+	for _, copy := range []string{"first", "second"} {
+		createMarked(t, "trash/restore-1", copy)
+		err := Delete("trash/restore-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Exist("trash/restore-1") {
+			t.Fatal("Deleted repository exist")
+		}
+	}
+	trashed, err := Trash("trash")
+	if err != nil || len(trashed) != 2 || trashed[0].Id != "trash/restore-1" || !trashed[0].Deleted.After(trashed[1].Deleted) {
+		t.Fatalf("Unexpected trash %+v: %v", trashed, err)
+	}
+	repos, _ := repoIds("trash")
+	if len(repos) != 0 {
+		t.Errorf("Trashed repositories are listed: %v", repos)
+	}
+
+	// the most recent copy is restored by default
+	restored, err := Restore("trash/restore-1", 0)
+	if err != nil || restored.Deleted != trashed[0].Deleted || marker(t, "trash/restore-1") != "second" {
+		t.Fatalf("Unexpected restore %+v: %v", restored, err)
+	}
+	_, err = Restore("trash/restore-1", trashed[1].Deleted.Unix())
+	if err == nil || !strings.Contains(err.Error(), "already exist") {
+		t.Errorf("Expected restore over existing repository to fail, got %v", err)
+	}
+
+	err = Delete("trash/restore-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = Restore("trash/restore-1", trashed[1].Deleted.Unix())
+	if err != nil || marker(t, "trash/restore-1") != "first" {
+		t.Fatalf("Unexpected restore of %v copy %+v: %v", trashed[1].Deleted, restored, err)
+	}
+	_, err = Restore("trash/restore-1", trashed[1].Deleted.Unix())
+	if err == nil || !strings.Contains(err.Error(), "already exist") {
+		t.Errorf("Expected restore over existing repository to fail, got %v", err)
+	}
+	os.RemoveAll(filepath.Join(config.RepoDir, "trash/restore-1"))
+	_, err = Restore("trash/restore-1", trashed[1].Deleted.Unix())
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected restored copy not found in trash, got %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	withTrash(t)
+	withLockFiles(t, false)
+	now := time.Now()
+	trash := func(repoId string, age time.Duration) string {
+		dir := filepath.Join(config.RepoDir, trashDir, fmt.Sprintf("%s.%d", repoId, now.Add(-age).Unix()))
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	expired := trash("purge/repo-1", 2*time.Hour)
+	recent := trash("purge/repo-1", time.Minute)
+	locked := trash("purge/repo-2", 3*time.Hour)
+
+	unlock, err := lockRepo("purge/repo-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	PurgeTrash()
+	unlock()
+
+	for dir, exist := range map[string]bool{expired: false, recent: true, locked: true} {
+		if _, err := os.Stat(dir); (err == nil) != exist {
+			t.Errorf("Expected %s to exist: %v", dir, exist)
+		}
+	}
+	PurgeTrash()
+	if _, err := os.Stat(locked); err == nil {
+		t.Errorf("Expected %s to be purged once unlocked", locked)
+	}
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // blob, tree, symlink, submodule
+	Mode string `json:"mode"`
+	Size *int64 `json:"size,omitempty"` // blobs and symlinks only
+	Id   string `json:"id"`
+}
+
+const (
+	modeSymlink = "120000"
+)
+
+// resolveTree returns tree id of `dir` at `ref`, empty `dir` for the root tree
+func resolveTree(repoDir, ref, dir string) (string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Ref `%s` is not supported", ref)
+	}
+	_, err := gitOutput(repoDir, "rev-parse", "-q", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Ref `%s` not found", ref)
+	}
+	object := ref + "^{tree}"
+	if dir != "" {
+		object = ref + ":" + dir
+	}
+	objectType, err := gitOutput(repoDir, "cat-file", "-t", object)
+	if err != nil {
+		return "", fmt.Errorf("Path `%s` not found", dir)
+	}
+	if objectType != "tree" {
+		return "", fmt.Errorf("Path `%s` is not a directory", dir)
+	}
+	return gitOutput(repoDir, "rev-parse", object)
+}
+
+func Tree(repoId, ref, dir string, recursive bool) ([]TreeEntry, error) {
+	repoDir := filepath.Join(config.RepoDir, repoId)
+	if ref == "" {
+		ref = "master"
+	}
+	dir = strings.Trim(dir, "/")
+	if dir == "." {
+		dir = ""
+	}
+
+	tree, err := resolveTree(repoDir, ref, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"ls-tree", "-l", "-z"}
+	if recursive {
+		args = append(args, "-r", "-t")
+	}
+	output, err := gitOutput(repoDir, append(args, tree)...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TreeEntry, 0)
+	for _, line := range strings.Split(output, "\x00") {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		tab := strings.Index(line, "\t")
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 4 {
+			continue
+		}
+		name := line[tab+1:]
+		entry := TreeEntry{
+			Name: path.Base(name),
+			Path: path.Join(dir, name),
+			Type: fields[1],
+			Mode: fields[0],
+			Id:   fields[2],
+		}
+		switch {
+		case entry.Type == "commit":
+			entry.Type = "submodule"
+		case entry.Type == "blob" && entry.Mode == modeSymlink:
+			entry.Type = "symlink"
+		}
+		if size, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+			entry.Size = &size
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
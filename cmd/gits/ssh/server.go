@@ -11,7 +11,6 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/agilestacks/git-service/cmd/gits/config"
-	"github.com/agilestacks/git-service/cmd/gits/extapi"
 	"github.com/agilestacks/git-service/cmd/gits/repo"
 	"github.com/agilestacks/git-service/cmd/gits/util"
 )
@@ -69,7 +68,7 @@ func accept(conn net.Conn, server *ssh.ServerConfig) {
 func checkKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	key64 := keyBase64(key)
 	keyPrint := keyFingerprint(key)
-	users, err := extapi.UsersBySshKey(key64, keyPrint)
+	users, err := repo.UsersBySshKey(key64, keyPrint)
 	if err != nil {
 		log.Printf("Unable to search for users by SSH key with fingerprint `%s`: %v", keyPrint, err)
 		return nil, err
@@ -119,9 +118,17 @@ func gitCommand(cmd string) string {
 	return cmd
 }
 
+// RFC 4254 6.4 Environment Variable Passing
+type envRequest struct {
+	Name  string
+	Value string
+}
+
 func handleRequests(users []string, sshChannel ssh.Channel, requests <-chan *ssh.Request) {
 	defer sshChannel.Close()
 
+	protocol := ""
+
 	for request := range requests {
 		payload := string(request.Payload)
 		if config.Debug {
@@ -137,20 +144,25 @@ func handleRequests(users []string, sshChannel ssh.Channel, requests <-chan *ssh
 			break
 
 		case "env":
-			parts := strings.Split(strings.Replace(payload, "\x00", "", -1), "\v")
-			if len(parts) != 2 {
+			var env envRequest
+			err := ssh.Unmarshal(request.Payload, &env)
+			if err != nil {
 				log.Printf("Bad SSH env request: %q", payload)
 				continue
 			}
-			envVar := strings.TrimLeft(parts[0], "\b")
-			value := parts[1]
 			if config.Debug {
-				log.Printf("SSH client requested env setup %s=%q", envVar, value)
+				log.Printf("SSH client requested env setup %s=%q", env.Name, env.Value)
+			}
+			if env.Name == "GIT_PROTOCOL" {
+				protocol = env.Value
+			}
+			if request.WantReply {
+				request.Reply(env.Name == "GIT_PROTOCOL", nil)
 			}
 			break
 
 		case "exec":
-			cmd, err := repo.GitServer(gitCommand(payload), sshChannel, sshChannel, sshChannel.Stderr(), users)
+			cmd, err := repo.GitServer(gitCommand(payload), protocol, sshChannel, sshChannel, sshChannel.Stderr(), users)
 			if err != nil {
 				log.Printf("Failed to start Git server: %v", err)
 				request.Reply(false, nil)
@@ -0,0 +1,253 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Personal access tokens for HTTPS Git, to be used as password instead of SSO password.
+   Token is `gpat_<id><secret>` and shown only once on creation, <repo_dir>/_tokens.json
+   keeps SHA-256 of the token. Token grants no more than the user has. */
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	tokenPrefix = "gpat_"
+	idHexLen    = 16
+	secretLen   = 16
+	tokensFile  = "_tokens.json"
+)
+
+type Token struct {
+	Id            string     `json:"id"`
+	UserId        string     `json:"userId"`
+	Description   string     `json:"description,omitempty"`
+	Scopes        []string   `json:"scopes"`
+	Repositories  []string   `json:"repositories,omitempty"`
+	Organizations []string   `json:"organizations,omitempty"`
+	Created       time.Time  `json:"created"`
+	Expires       *time.Time `json:"expires,omitempty"`
+}
+
+type storedToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+var tokensLock sync.Mutex
+
+func IsToken(str string) bool {
+	return strings.HasPrefix(str, tokenPrefix)
+}
+
+// Id returns token id, or empty string if secret is not well-formed token
+func Id(secret string) string {
+	if !IsToken(secret) || len(secret) != len(tokenPrefix)+idHexLen+2*secretLen {
+		return ""
+	}
+	return secret[len(tokenPrefix) : len(tokenPrefix)+idHexLen]
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func readTokens() (map[string]storedToken, error) {
+	file := filepath.Join(config.RepoDir, tokensFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]storedToken), nil
+		}
+		return nil, fmt.Errorf("Unable to read `%s`: %v", file, err)
+	}
+	tokens := make(map[string]storedToken)
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal `%s`: %v", file, err)
+	}
+	return tokens, nil
+}
+
+func writeTokens(tokens map[string]storedToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(config.RepoDir, tokensFile)
+	temp := file + ".tmp"
+	err = ioutil.WriteFile(temp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write `%s`: %v", temp, err)
+	}
+	return os.Rename(temp, file)
+}
+
+func (token *Token) expired(now time.Time) bool {
+	return token.Expires != nil && !now.Before(*token.Expires)
+}
+
+func validate(token *Token) error {
+	if token.UserId == "" {
+		return fmt.Errorf("Token without user id is not supported")
+	}
+	if len(token.Scopes) == 0 {
+		return fmt.Errorf("Token without scopes is not supported, use `%s` and/or `%s`", ScopeRead, ScopeWrite)
+	}
+	for _, scope := range token.Scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return fmt.Errorf("Token scope `%s` is not supported, use `%s` and/or `%s`", scope, ScopeRead, ScopeWrite)
+		}
+	}
+	for _, repoId := range token.Repositories {
+		parts := strings.Split(repoId, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Token repository `%s` is not supported, use `organization/repository`", repoId)
+		}
+	}
+	for _, org := range token.Organizations {
+		if org == "" || strings.Contains(org, "/") {
+			return fmt.Errorf("Token organization `%s` is not supported", org)
+		}
+	}
+	if token.Expires == nil && config.TokenTtl > 0 {
+		expires := token.Created.Add(config.TokenTtl)
+		token.Expires = &expires
+	}
+	if token.Expires != nil && token.expired(token.Created) {
+		return fmt.Errorf("Token expiry in the past is not supported")
+	}
+	return nil
+}
+
+// Create stores the token and returns it's secret value, to be shown only once
+func Create(token *Token) (string, error) {
+	buf := make([]byte, idHexLen/2+secretLen)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate token: %v", err)
+	}
+	secret := tokenPrefix + hex.EncodeToString(buf)
+	token.Id = secret[len(tokenPrefix) : len(tokenPrefix)+idHexLen]
+	token.Created = time.Now().UTC().Truncate(time.Second)
+	err = validate(token)
+	if err != nil {
+		return "", err
+	}
+
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	tokens, err := readTokens()
+	if err != nil {
+		return "", err
+	}
+	// drop expired tokens on every update
+	for id, stored := range tokens {
+		if stored.expired(token.Created) {
+			delete(tokens, id)
+		}
+	}
+	tokens[token.Id] = storedToken{Token: *token, Hash: hash(secret)}
+	err = writeTokens(tokens)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// List returns user tokens, or all tokens if user is empty, most recent first
+func List(userId string) ([]Token, error) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	tokens, err := readTokens()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Token, 0, len(tokens))
+	now := time.Now()
+	for _, stored := range tokens {
+		if (userId == "" || stored.UserId == userId) && !stored.expired(now) {
+			list = append(list, stored.Token)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created.Equal(list[j].Created) {
+			return list[i].Id < list[j].Id
+		}
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+func Revoke(id string) error {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	tokens, err := readTokens()
+	if err != nil {
+		return err
+	}
+	if _, exist := tokens[id]; !exist {
+		return fmt.Errorf("Token `%s` not found", id)
+	}
+	delete(tokens, id)
+	return writeTokens(tokens)
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies token grants `write` or read access to the repo and returns token user id
+func Check(secret, repoId string, write bool) (string, error) {
+	id := Id(secret)
+	if id == "" {
+		return "", fmt.Errorf("Bad token format")
+	}
+
+	tokensLock.Lock()
+	tokens, err := readTokens()
+	tokensLock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	stored, exist := tokens[id]
+	if !exist || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hash(secret))) != 1 {
+		return "", fmt.Errorf("Token `%s` not found", id)
+	}
+	if stored.expired(time.Now()) {
+		return "", fmt.Errorf("Token `%s` expired at %s", id, stored.Expires.Format(time.RFC3339))
+	}
+	if write && !contains(stored.Scopes, ScopeWrite) {
+		return "", fmt.Errorf("Token `%s` has no `%s` scope", id, ScopeWrite)
+	}
+	if len(stored.Repositories) > 0 || len(stored.Organizations) > 0 {
+		org := strings.SplitN(repoId, "/", 2)[0]
+		if !contains(stored.Repositories, repoId) && !contains(stored.Organizations, org) {
+			return "", fmt.Errorf("Token `%s` is restricted to other repositories", id)
+		}
+	}
+	return stored.UserId, nil
+}
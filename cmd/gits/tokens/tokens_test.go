@@ -0,0 +1,74 @@
+package tokens
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+func init() {
+	repoDir, err := ioutil.TempDir("", "gits-test-")
+	if err != nil {
+		panic(err)
+	}
+	config.RepoDir = repoDir
+	config.TokenTtl = time.Hour
+}
+
+func TestTokens(t *testing.T) {
+	read := Token{UserId: "alice", Scopes: []string{ScopeRead}, Organizations: []string{"acme"}}
+	readSecret, err := Create(&read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Expires == nil || !read.Expires.Equal(read.Created.Add(time.Hour)) {
+		t.Errorf("Expected default expiry, got %v", read.Expires)
+	}
+	write := Token{UserId: "alice", Scopes: []string{ScopeWrite}, Repositories: []string{"acme/web-1"}}
+	writeSecret, err := Create(&write)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		secret string
+		repoId string
+		write  bool
+		ok     bool
+	}{
+		{readSecret, "acme/web-1", false, true},
+		{readSecret, "acme/web-1", true, false},
+		{readSecret, "other/web-1", false, false},
+		{writeSecret, "acme/web-1", true, true},
+		{writeSecret, "acme/api-2", false, false},
+		{readSecret[:len(readSecret)-1] + "x", "acme/web-1", false, false},
+	}
+	for _, check := range checks {
+		userId, err := Check(check.secret, check.repoId, check.write)
+		if check.ok && (err != nil || userId != "alice") {
+			t.Errorf("Expected access to `%s` (write %v), got %v", check.repoId, check.write, err)
+		}
+		if !check.ok && err == nil {
+			t.Errorf("Expected no access to `%s` (write %v)", check.repoId, check.write)
+		}
+	}
+
+	_, err = Create(&Token{UserId: "alice", Scopes: []string{"admin"}})
+	if err == nil {
+		t.Error("Expected `admin` scope to be rejected")
+	}
+
+	err = Revoke(write.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Check(writeSecret, "acme/web-1", true); err == nil {
+		t.Error("Expected revoked token to be rejected")
+	}
+	list, err := List("alice")
+	if err != nil || len(list) != 1 || list[0].Id != read.Id {
+		t.Errorf("Unexpected tokens list %v: %v", list, err)
+	}
+}
@@ -0,0 +1,310 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+/* Outbound notifications on ref updates. Global webhook is set by -webhook_url, per organization
+   webhooks are kept in <repo_dir>/_webhooks.json and managed via HTTP API. Every event is delivered
+   to both, if configured. Body is signed with HMAC-SHA256 of the webhook secret:
+   X-Gits-Signature: sha256=<hex> */
+
+const (
+	EventPush = "push"
+
+	TransportHttp = "http"
+	TransportSsh  = "ssh"
+	TransportApi  = "api"
+
+	webhooksFile    = "_webhooks.json"
+	deliveryLogSize = 200
+)
+
+type Event struct {
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	Ref        string    `json:"ref"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+	Pusher     string    `json:"pusher,omitempty"` // comma separated if SSH key is shared by several users
+	Transport  string    `json:"transport"`        // http, ssh, api
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type Webhook struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type Delivery struct {
+	Id           string    `json:"id"`
+	Event        Event     `json:"event"`
+	Url          string    `json:"url"`
+	Status       int       `json:"status,omitempty"`
+	Attempts     int       `json:"attempts"`
+	Delivered    bool      `json:"delivered"`
+	Error        string    `json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	organization string
+}
+
+var (
+	retryBackoff = time.Second
+
+	webhooksLock sync.Mutex
+
+	deliveriesLock sync.Mutex
+	deliveries     = make([]*Delivery, 0, deliveryLogSize)
+)
+
+func randomId() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+func orgId(repoId string) string {
+	return strings.SplitN(repoId, "/", 2)[0]
+}
+
+func readWebhooks() (map[string]Webhook, error) {
+	file := filepath.Join(config.RepoDir, webhooksFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]Webhook), nil
+		}
+		return nil, fmt.Errorf("Unable to read `%s`: %v", file, err)
+	}
+	hooks := make(map[string]Webhook)
+	err = json.Unmarshal(data, &hooks)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal `%s`: %v", file, err)
+	}
+	return hooks, nil
+}
+
+func writeWebhooks(hooks map[string]Webhook) error {
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(config.RepoDir, webhooksFile)
+	temp := file + ".tmp"
+	err = ioutil.WriteFile(temp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to write `%s`: %v", temp, err)
+	}
+	return os.Rename(temp, file)
+}
+
+// Get returns organization webhook, nil if not set
+func Get(org string) (*Webhook, error) {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+
+	hooks, err := readWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	hook, exist := hooks[org]
+	if !exist {
+		return nil, nil
+	}
+	return &hook, nil
+}
+
+func Set(org string, hook *Webhook) error {
+	if !strings.HasPrefix(hook.Url, "http://") && !strings.HasPrefix(hook.Url, "https://") {
+		return fmt.Errorf("Webhook URL `%s` is not supported", hook.Url)
+	}
+
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+
+	hooks, err := readWebhooks()
+	if err != nil {
+		return err
+	}
+	hooks[org] = *hook
+	return writeWebhooks(hooks)
+}
+
+func Delete(org string) error {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+
+	hooks, err := readWebhooks()
+	if err != nil {
+		return err
+	}
+	if _, exist := hooks[org]; !exist {
+		return fmt.Errorf("No webhook for organization `%s` found", org)
+	}
+	delete(hooks, org)
+	return writeWebhooks(hooks)
+}
+
+// targets returns webhooks the repo events are delivered to
+func targets(repoId string) []Webhook {
+	hooks := make([]Webhook, 0, 2)
+	if config.WebhookUrl != "" {
+		hooks = append(hooks, Webhook{Url: config.WebhookUrl, Secret: config.WebhookSecret})
+	}
+	hook, err := Get(orgId(repoId))
+	if err != nil {
+		log.Printf("Unable to get `%s` webhook: %v", repoId, err)
+	} else if hook != nil {
+		hooks = append(hooks, *hook)
+	}
+	return hooks
+}
+
+// Configured returns true if repo events are delivered anywhere, so that caller could skip collecting them
+func Configured(repoId string) bool {
+	return len(targets(repoId)) > 0
+}
+
+// Notify delivers event in background
+func Notify(event Event) {
+	event.Id = randomId()
+	if event.Type == "" {
+		event.Type = EventPush
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	for _, hook := range targets(event.Repository) {
+		delivery := &Delivery{
+			Id:           randomId(),
+			Event:        event,
+			Url:          hook.Url,
+			Timestamp:    event.Timestamp,
+			organization: orgId(event.Repository),
+		}
+		logDelivery(delivery)
+		go deliver(delivery, hook.Secret)
+	}
+}
+
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(delivery *Delivery, secret string) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		updateDelivery(delivery, 0, false, err)
+		return
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; attempt <= config.WebhookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		status, err := post(delivery, body, secret)
+		ok := err == nil
+		updateDelivery(delivery, status, ok, err)
+		if ok {
+			if config.Debug {
+				log.Printf("Webhook %s delivered `%s` %s event to %s", delivery.Id,
+					delivery.Event.Repository, delivery.Event.Type, delivery.Url)
+			}
+			return
+		}
+		// client errors won't go away on retry
+		if status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+			break
+		}
+	}
+	log.Printf("Unable to deliver webhook %s `%s` %s event to %s: %s", delivery.Id,
+		delivery.Event.Repository, delivery.Event.Type, delivery.Url, delivery.Error)
+}
+
+func post(delivery *Delivery, body []byte, secret string) (int, error) {
+	req, err := http.NewRequest("POST", delivery.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("User-Agent", "gits-webhook")
+	req.Header.Set("X-Gits-Event", delivery.Event.Type)
+	req.Header.Set("X-Gits-Delivery", delivery.Id)
+	if secret != "" {
+		req.Header.Set("X-Gits-Signature", Sign(body, secret))
+	}
+	client := &http.Client{Timeout: config.WebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Got %s HTTP status", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func logDelivery(delivery *Delivery) {
+	deliveriesLock.Lock()
+	defer deliveriesLock.Unlock()
+
+	if len(deliveries) >= deliveryLogSize {
+		copy(deliveries, deliveries[1:])
+		deliveries = deliveries[:len(deliveries)-1]
+	}
+	deliveries = append(deliveries, delivery)
+}
+
+func updateDelivery(delivery *Delivery, status int, delivered bool, err error) {
+	deliveriesLock.Lock()
+	defer deliveriesLock.Unlock()
+
+	delivery.Attempts++
+	delivery.Status = status
+	delivery.Delivered = delivered
+	delivery.Error = ""
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+}
+
+// Deliveries returns recent deliveries of organization repositories events, most recent first
+func Deliveries(org string) []Delivery {
+	deliveriesLock.Lock()
+	defer deliveriesLock.Unlock()
+
+	log := make([]Delivery, 0)
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		if deliveries[i].organization == org {
+			log = append(log, *deliveries[i])
+		}
+	}
+	return log
+}
@@ -0,0 +1,137 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agilestacks/git-service/cmd/gits/config"
+)
+
+type received struct {
+	signature string
+	event     Event
+}
+
+func init() {
+	repoDir, err := ioutil.TempDir("", "gits-test-")
+	if err != nil {
+		panic(err)
+	}
+	config.RepoDir = repoDir
+	config.WebhookRetries = 2
+	config.WebhookTimeout = 5 * time.Second
+	retryBackoff = 10 * time.Millisecond
+}
+
+func standIn(failures int) (*httptest.Server, chan received) {
+	events := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		var event Event
+		json.Unmarshal(body, &event)
+		if req.Header.Get("X-Gits-Signature") != "" &&
+			req.Header.Get("X-Gits-Signature") != Sign(body, "hooksecret") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- received{req.Header.Get("X-Gits-Signature"), event}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return server, events
+}
+
+func waitEvent(events chan received, t *testing.T) received {
+	select {
+	case r := <-events:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	return received{}
+}
+
+// waitDeliveries polls deliveries log until delivery attempts are recorded
+func waitDeliveries(org string, done func(Delivery) bool) []Delivery {
+	var deliveries []Delivery
+	for i := 0; i < 100; i++ {
+		deliveries = Deliveries(org)
+		if len(deliveries) > 0 && done(deliveries[0]) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return deliveries
+}
+
+func resetDeliveries() {
+	deliveriesLock.Lock()
+	deliveries = deliveries[:0]
+	deliveriesLock.Unlock()
+}
+
+func TestOrganizationWebhookIsSignedAndRetried(t *testing.T) {
+	resetDeliveries()
+	server, events := standIn(1)
+	defer server.Close()
+
+	err := Set("org1", &Webhook{Url: server.URL, Secret: "hooksecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Delete("org1")
+	if !Configured("org1/repo-1") || Configured("org2/repo-1") {
+		t.Errorf("webhook is configured for wrong organization")
+	}
+
+	Notify(Event{Repository: "org1/repo-1", Ref: "refs/heads/master",
+		Before: "1111111111111111111111111111111111111111", After: "2222222222222222222222222222222222222222",
+		Pusher: "user1", Transport: TransportSsh})
+
+	r := waitEvent(events, t)
+	if r.signature == "" {
+		t.Errorf("webhook returned no signature")
+	}
+	if r.event.Type != EventPush || r.event.Ref != "refs/heads/master" || r.event.Pusher != "user1" ||
+		r.event.Transport != TransportSsh || r.event.Id == "" {
+		t.Errorf("webhook returned unexpected event: got %+v", r.event)
+	}
+
+	deliveries := waitDeliveries("org1", func(d Delivery) bool { return d.Delivered })
+	if len(deliveries) != 1 {
+		t.Fatalf("webhook deliveries log returned unexpected count: got %v want 1", len(deliveries))
+	}
+	if !deliveries[0].Delivered || deliveries[0].Attempts != 2 || deliveries[0].Event.Id != r.event.Id {
+		t.Errorf("webhook deliveries log returned unexpected delivery: got %+v", deliveries[0])
+	}
+}
+
+func TestGlobalWebhookGivesUpOnClientError(t *testing.T) {
+	resetDeliveries()
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer failed.Close()
+
+	config.WebhookUrl = failed.URL
+	defer func() { config.WebhookUrl = "" }()
+
+	Notify(Event{Repository: "org3/repo-1", Ref: "refs/heads/master"})
+
+	deliveries := waitDeliveries("org3", func(d Delivery) bool { return d.Attempts > 0 })
+	if len(deliveries) != 1 || deliveries[0].Delivered || deliveries[0].Status != http.StatusNotFound {
+		t.Fatalf("webhook deliveries log returned unexpected deliveries: got %+v", deliveries)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if attempts := Deliveries("org3")[0].Attempts; attempts != 1 {
+		t.Errorf("webhook retried on client error: got %v attempts want 1", attempts)
+	}
+}
@@ -4,11 +4,9 @@ go 1.14
 
 require (
 	github.com/aws/aws-sdk-go v1.31.15
-	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-git/go-billy/v5 v5.0.0
+	github.com/go-git/go-git/v5 v5.1.0
 	github.com/gorilla/mux v1.7.4
-	github.com/kr/pretty v0.1.0 // indirect
 	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
-	golang.org/x/sys v0.0.0-20190726091711-fc99dfbffb4e // indirect
-	golang.org/x/text v0.3.2 // indirect
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/yaml.v2 v2.2.4
 )